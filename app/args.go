@@ -2,17 +2,247 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
 )
 
-func tryParseResolverArg() (bool, string) {
-	var resolverAddress string
-	flag.StringVar(&resolverAddress, "resolver", "", "Address of the resolver")
+type args struct {
+	// The configuration file the other arguments were read from, if any.
+	configFile string
+	// Addresses to listen on, each of them over every transport.
+	listenAddresses []string
+	// The transports to listen on: udp, tcp or both.
+	transports []string
+	// Addresses of the upstream resolvers, empty if the internal resolver
+	// should be used.
+	resolverAddresses []string
+	// Whether to resolve iteratively, starting from the root servers.
+	recursive bool
+	// Addresses of the root servers, empty for the default ones.
+	rootHints []string
+	// The port name servers are queried on when resolving iteratively.
+	recursivePort int
+	// Master files of the zones to serve authoritatively.
+	zoneFiles []string
+	// How the upstream resolver to ask is chosen.
+	resolverPolicy string
+	// The maximum number of requests resolved at the same time, per UDP
+	// socket and per TCP connection.
+	maxConcurrency int
+	// The maximum number of RRsets cached, 0 disables the cache.
+	cacheSize int
+	// Bounds for how long records are cached, in seconds.
+	cacheMinTTL uint
+	cacheMaxTTL uint
+	// Names of the middleware stages requests pass through, in order.
+	middlewares []string
+	// Networks allowed to and denied from sending requests, for the acl
+	// stage.
+	aclAllow []string
+	aclDeny  []string
+	// Requests per second and burst size allowed per client, for the
+	// ratelimit stage.
+	rateLimit float64
+	rateBurst int
+	// Whether every request received is written to the query log.
+	logRequests bool
+	// The JSON file the query log is written to, empty for none.
+	logFile string
+	// Whether the query log is written to stdout as well.
+	logStdout bool
+	// The size in MiB at which the query log file is rotated, and how many
+	// rotated files are kept.
+	logMaxSize    uint
+	logMaxBackups uint
+	// The fraction of requests written to the query log.
+	logSampleRate float64
+	// The Unix socket or file dnstap messages are written to, at most one of
+	// them, and the identity sent along with them.
+	dnstapSocket   string
+	dnstapFile     string
+	dnstapIdentity string
+	// The address the /metrics endpoint is served on over HTTP, empty if
+	// metrics aren't exposed.
+	metricsListen string
+	// How long requests in flight may take to be answered when shutting
+	// down, in seconds.
+	shutdownGrace uint
+}
+
+// Parses the command line. If it names a configuration file, the arguments
+// are read from the file, and the flags given on the command line override
+// them.
+func parseArgs() (*args, error) {
+	a := &args{}
+	defineFlags(flag.CommandLine, a)
 	flag.Parse()
 
+	return loadArgs(a.configFile, flag.CommandLine)
+}
+
+// Reads the arguments from the configuration file, if there is one, and
+// applies the flags set on the command line to them. Called again to reload
+// the configuration.
+func loadArgs(configFile string, commandLine *flag.FlagSet) (*args, error) {
+	a := &args{}
+	overrides := flag.NewFlagSet("overrides", flag.ContinueOnError)
+	defineFlags(overrides, a)
+
+	if configFile != "" {
+		err := loadConfigFile(configFile, a)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	set := make(map[string]bool)
+	commandLine.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+		if err == nil {
+			err = overrides.Set(f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Choosing a resolver on the command line replaces the one of the file,
+	// rather than conflicting with it
+	if set["resolver"] && a.useForwardingResolver() && !set["recursive"] {
+		a.recursive = false
+	}
+	if set["recursive"] && a.recursive && !set["resolver"] {
+		a.resolverAddresses = nil
+	}
+
+	err = a.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Defines the flags of all arguments on the flag set, with their defaults.
+func defineFlags(flags *flag.FlagSet, a *args) {
+	a.listenAddresses = []string{defaultListenAddress}
+	a.transports = []string{"udp", "tcp"}
+
+	flags.StringVar(&a.configFile, "config", "", "TOML file to read the configuration from, flags given as well override it")
+	flags.Var((*listValue)(&a.listenAddresses), "listen", "Comma separated addresses to listen on")
+	flags.Var((*listValue)(&a.transports), "transports", "Comma separated transports to listen on: udp, tcp")
+	flags.Var((*listValue)(&a.resolverAddresses), "resolver", "Address of the resolver, or a comma separated list of addresses")
+	flags.Var((*listValue)(&a.zoneFiles), "zone", "Master file of a zone to serve, or a comma separated list of files")
+	flags.BoolVar(&a.recursive, "recursive", false, "Resolve iteratively starting from the root servers, instead of forwarding")
+	flags.Var((*listValue)(&a.rootHints), "root-hints", "Comma separated addresses of the root servers to start iterative resolution from")
+	flags.IntVar(&a.recursivePort, "recursive-port", 53, "Port to query name servers on when resolving iteratively")
+	flags.StringVar(&a.resolverPolicy, "resolver-policy", "sequential", "How to choose between resolvers: sequential, round-robin, random or lowest-latency")
+	flags.IntVar(&a.maxConcurrency, "max-concurrency", 256, "Maximum number of requests resolved concurrently per UDP socket and per TCP connection")
+	flags.IntVar(&a.cacheSize, "cache-size", 4096, "Maximum number of RRsets to cache, 0 disables the cache")
+	flags.UintVar(&a.cacheMinTTL, "cache-min-ttl", 0, "Minimum time in seconds to cache records for")
+	flags.UintVar(&a.cacheMaxTTL, "cache-max-ttl", 86400, "Maximum time in seconds to cache records for")
+	flags.Var((*listValue)(&a.middlewares), "middleware", "Comma separated middleware stages requests pass through, in order: acl, ratelimit, minimal")
+	flags.Var((*listValue)(&a.aclAllow), "acl-allow", "Comma separated networks allowed to send requests, all if empty")
+	flags.Var((*listValue)(&a.aclDeny), "acl-deny", "Comma separated networks whose requests are refused")
+	flags.Float64Var(&a.rateLimit, "rate-limit", 100, "Requests per second allowed per client")
+	flags.IntVar(&a.rateBurst, "rate-burst", 200, "Requests a client may send in a burst above the rate limit")
+	flags.UintVar(&a.shutdownGrace, "shutdown-grace", 5, "Seconds to let requests in flight finish when shutting down")
+	flags.BoolVar(&a.logRequests, "log-requests", true, "Write every request received to the query log")
+	flags.StringVar(&a.logFile, "log-file", "", "JSON file to write the query log to")
+	flags.BoolVar(&a.logStdout, "log-stdout", true, "Write the query log to stdout")
+	flags.UintVar(&a.logMaxSize, "log-max-size", 100, "Size in MiB at which the query log file is rotated, 0 never rotates it")
+	flags.UintVar(&a.logMaxBackups, "log-max-backups", 5, "Number of rotated query log files to keep")
+	flags.Float64Var(&a.logSampleRate, "log-sample-rate", 1, "Fraction of requests to write to the query log, between 0 and 1")
+	flags.StringVar(&a.dnstapSocket, "dnstap-socket", "", "Unix socket of a dnstap reader to send messages to")
+	flags.StringVar(&a.dnstapFile, "dnstap-file", "", "File to write dnstap messages to")
+	flags.StringVar(&a.dnstapIdentity, "dnstap-identity", "", "Identity sent with dnstap messages, the host name by default")
+	flags.StringVar(&a.metricsListen, "metrics-listen", "", "Address to serve Prometheus metrics on at /metrics, e.g. 127.0.0.1:9153")
+}
+
+// Checks that the arguments make sense together, so that mistakes are
+// reported at startup rather than when the first request arrives.
+func (a *args) validate() error {
+	if len(a.listenAddresses) == 0 {
+		return fmt.Errorf("no addresses to listen on")
+	}
+
+	if len(a.transports) == 0 {
+		return fmt.Errorf("no transports to listen on")
+	}
+
+	for _, transport := range a.transports {
+		if transport != "udp" && transport != "tcp" {
+			return fmt.Errorf("invalid transport %q, must be udp or tcp", transport)
+		}
+	}
+
+	if a.maxConcurrency < 1 {
+		return fmt.Errorf("max concurrency must be at least 1, got %d", a.maxConcurrency)
+	}
+
+	if a.useForwardingResolver() && a.recursive {
+		return fmt.Errorf("-resolver and -recursive can't be combined")
+	}
+
+	_, err := dns.ParseUpstreamPolicy(a.resolverPolicy)
+	if err != nil {
+		return fmt.Errorf("invalid resolver policy: %w", err)
+	}
+
+	if a.recursivePort < 1 || a.recursivePort > 0xFFFF {
+		return fmt.Errorf("invalid recursive port %d", a.recursivePort)
+	}
+
+	if a.cacheSize < 0 {
+		return fmt.Errorf("cache size can't be negative, got %d", a.cacheSize)
+	}
+
+	if a.cacheMinTTL > a.cacheMaxTTL {
+		return fmt.Errorf("minimum cache TTL %d exceeds maximum of %d", a.cacheMinTTL, a.cacheMaxTTL)
+	}
+
+	if a.logSampleRate < 0 || a.logSampleRate > 1 {
+		return fmt.Errorf("log sample rate must be between 0 and 1, got %g", a.logSampleRate)
+	}
+
+	if a.dnstapSocket != "" && a.dnstapFile != "" {
+		return fmt.Errorf("-dnstap-socket and -dnstap-file can't be combined")
+	}
+
+	// Building the stages checks their options
+	_, err = buildMiddlewares(a)
+	return err
+}
+
+func (a *args) useForwardingResolver() bool {
 	// Check if the resolver flag is provided
-	if resolverAddress == "" {
-		return false, ""
+	return len(a.resolverAddresses) > 0
+}
+
+// A flag holding a comma separated list.
+type listValue []string
+
+func (l *listValue) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	*l = splitList(value)
+	return nil
+}
+
+// Splits a comma separated flag value into its non-empty elements.
+func splitList(value string) []string {
+	elements := make([]string, 0)
+	for _, element := range strings.Split(value, ",") {
+		element = strings.TrimSpace(element)
+		if element != "" {
+			elements = append(elements, element)
+		}
 	}
 
-	return true, resolverAddress
+	return elements
 }
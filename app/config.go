@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+)
+
+// Sets an argument from a value of the configuration file.
+type configSetter func(a *args, value *tomlValue) error
+
+// The tables of the configuration file and the keys each of them may hold.
+// For example:
+//
+//	[server]
+//	listen = ["127.0.0.1:2053"]
+//	transports = ["udp", "tcp"]
+//	max_concurrency = 256
+//	shutdown_grace = 5
+//
+//	[resolver]
+//	mode = "forward"            # or "recursive" or "internal"
+//	upstreams = ["192.0.2.53:53"]
+//	policy = "sequential"
+//	root_hints = []
+//	recursive_port = 53
+//
+//	[zones]
+//	files = ["example.com.zone"]
+//
+//	[cache]
+//	size = 4096
+//	min_ttl = 0
+//	max_ttl = 86400
+//
+//	[middleware]
+//	stages = ["acl", "ratelimit"]
+//
+//	[acl]
+//	allow = ["127.0.0.0/8"]
+//	deny = []
+//
+//	[rate_limit]
+//	rate = 100
+//	burst = 200
+//
+//	[logging]
+//	requests = true
+//	file = "/var/log/dns/queries.json"
+//	stdout = false
+//	max_size = 100
+//	max_backups = 5
+//	sample_rate = 0.1
+//
+//	[dnstap]
+//	socket = "/var/run/dnstap.sock"
+//	identity = "ns1"
+//
+//	[metrics]
+//	listen = "127.0.0.1:9153"
+var configTables = map[string]map[string]configSetter{
+	"server": {
+		"listen":          stringsSetter(func(a *args) *[]string { return &a.listenAddresses }),
+		"transports":      stringsSetter(func(a *args) *[]string { return &a.transports }),
+		"max_concurrency": intSetter(func(a *args) *int { return &a.maxConcurrency }),
+		"shutdown_grace":  uintSetter(func(a *args) *uint { return &a.shutdownGrace }),
+	},
+	"resolver": {
+		"mode":           setResolverMode,
+		"upstreams":      stringsSetter(func(a *args) *[]string { return &a.resolverAddresses }),
+		"policy":         stringSetter(func(a *args) *string { return &a.resolverPolicy }),
+		"root_hints":     stringsSetter(func(a *args) *[]string { return &a.rootHints }),
+		"recursive_port": intSetter(func(a *args) *int { return &a.recursivePort }),
+	},
+	"zones": {
+		"files": stringsSetter(func(a *args) *[]string { return &a.zoneFiles }),
+	},
+	"cache": {
+		"size":    intSetter(func(a *args) *int { return &a.cacheSize }),
+		"min_ttl": uintSetter(func(a *args) *uint { return &a.cacheMinTTL }),
+		"max_ttl": uintSetter(func(a *args) *uint { return &a.cacheMaxTTL }),
+	},
+	"middleware": {
+		"stages": stringsSetter(func(a *args) *[]string { return &a.middlewares }),
+	},
+	"acl": {
+		"allow": stringsSetter(func(a *args) *[]string { return &a.aclAllow }),
+		"deny":  stringsSetter(func(a *args) *[]string { return &a.aclDeny }),
+	},
+	"rate_limit": {
+		"rate":  floatSetter(func(a *args) *float64 { return &a.rateLimit }),
+		"burst": intSetter(func(a *args) *int { return &a.rateBurst }),
+	},
+	"logging": {
+		"requests":    boolSetter(func(a *args) *bool { return &a.logRequests }),
+		"file":        stringSetter(func(a *args) *string { return &a.logFile }),
+		"stdout":      boolSetter(func(a *args) *bool { return &a.logStdout }),
+		"max_size":    uintSetter(func(a *args) *uint { return &a.logMaxSize }),
+		"max_backups": uintSetter(func(a *args) *uint { return &a.logMaxBackups }),
+		"sample_rate": floatSetter(func(a *args) *float64 { return &a.logSampleRate }),
+	},
+	"dnstap": {
+		"socket":   stringSetter(func(a *args) *string { return &a.dnstapSocket }),
+		"file":     stringSetter(func(a *args) *string { return &a.dnstapFile }),
+		"identity": stringSetter(func(a *args) *string { return &a.dnstapIdentity }),
+	},
+	"metrics": {
+		"listen": stringSetter(func(a *args) *string { return &a.metricsListen }),
+	},
+}
+
+// Reads the arguments from a TOML configuration file. Arguments the file
+// doesn't mention keep their value.
+func loadConfigFile(path string, a *args) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration: %w", err)
+	}
+
+	tables, err := parseTOML(string(content))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, table := range tables {
+		if table.name == "" && len(table.keys) > 0 {
+			key := table.keys[0]
+			return fmt.Errorf("%s: line %d: key %q must be in a table, e.g. [server]", path, table.values[key].line, key)
+		}
+
+		setters, ok := configTables[table.name]
+		if !ok && table.name != "" {
+			return fmt.Errorf("%s: line %d: unknown table [%s]", path, table.line, table.name)
+		}
+
+		for _, key := range table.keys {
+			value := table.values[key]
+			setter, ok := setters[key]
+			if !ok {
+				return fmt.Errorf("%s: line %d: unknown key %q in [%s]", path, value.line, key, table.name)
+			}
+
+			err := setter(a, value)
+			if err != nil {
+				return fmt.Errorf("%s: line %d: %s.%s: %w", path, value.line, table.name, key, err)
+			}
+		}
+	}
+
+	return checkResolverMode(path, tables, a)
+}
+
+// Checks that the upstreams agree with the resolver mode, which can only be
+// done once the whole [resolver] table has been read.
+func checkResolverMode(path string, tables []*tomlTable, a *args) error {
+	for _, table := range tables {
+		mode, ok := table.values["mode"]
+		if table.name != "resolver" || !ok {
+			continue
+		}
+
+		if mode.value == "forward" && len(a.resolverAddresses) == 0 {
+			return fmt.Errorf("%s: line %d: resolver.mode is forward, but resolver.upstreams is empty", path, mode.line)
+		}
+
+		if mode.value != "forward" && len(a.resolverAddresses) > 0 {
+			return fmt.Errorf("%s: line %d: resolver.upstreams is only used in forward mode", path, mode.line)
+		}
+	}
+
+	return nil
+}
+
+func setResolverMode(a *args, value *tomlValue) error {
+	mode, ok := value.value.(string)
+	if !ok {
+		return fmt.Errorf("expected a string")
+	}
+
+	switch mode {
+	case "forward":
+		a.recursive = false
+	case "recursive":
+		a.recursive = true
+	case "internal":
+		a.recursive = false
+		a.resolverAddresses = nil
+	default:
+		return fmt.Errorf("unknown mode %q, must be forward, recursive or internal", mode)
+	}
+
+	return nil
+}
+
+func stringSetter(field func(a *args) *string) configSetter {
+	return func(a *args, value *tomlValue) error {
+		s, ok := value.value.(string)
+		if !ok {
+			return fmt.Errorf("expected a string")
+		}
+
+		*field(a) = s
+		return nil
+	}
+}
+
+func stringsSetter(field func(a *args) *[]string) configSetter {
+	return func(a *args, value *tomlValue) error {
+		elements, ok := value.value.([]tomlValue)
+		if !ok {
+			return fmt.Errorf("expected an array of strings")
+		}
+
+		strings := make([]string, 0, len(elements))
+		for _, element := range elements {
+			s, ok := element.value.(string)
+			if !ok {
+				return fmt.Errorf("expected an array of strings")
+			}
+
+			strings = append(strings, s)
+		}
+
+		*field(a) = strings
+		return nil
+	}
+}
+
+func intSetter(field func(a *args) *int) configSetter {
+	return func(a *args, value *tomlValue) error {
+		i, ok := value.value.(int64)
+		if !ok || i < math.MinInt32 || i > math.MaxInt32 {
+			return fmt.Errorf("expected an integer")
+		}
+
+		*field(a) = int(i)
+		return nil
+	}
+}
+
+func uintSetter(field func(a *args) *uint) configSetter {
+	return func(a *args, value *tomlValue) error {
+		i, ok := value.value.(int64)
+		if !ok || i < 0 || i > math.MaxUint32 {
+			return fmt.Errorf("expected a non-negative integer")
+		}
+
+		*field(a) = uint(i)
+		return nil
+	}
+}
+
+func floatSetter(field func(a *args) *float64) configSetter {
+	return func(a *args, value *tomlValue) error {
+		var f float64
+		switch v := value.value.(type) {
+		case float64:
+			f = v
+		case int64:
+			f = float64(v)
+		default:
+			return fmt.Errorf("expected a number")
+		}
+
+		// TOML allows inf and nan, which no argument has a use for
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return fmt.Errorf("expected a finite number, got %v", f)
+		}
+
+		*field(a) = f
+		return nil
+	}
+}
+
+func boolSetter(field func(a *args) *bool) configSetter {
+	return func(a *args, value *tomlValue) error {
+		b, ok := value.value.(bool)
+		if !ok {
+			return fmt.Errorf("expected true or false")
+		}
+
+		*field(a) = b
+		return nil
+	}
+}
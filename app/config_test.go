@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, lines ...string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// Loads the configuration file as the server would, with the given flags on
+// the command line.
+func loadTestArgs(t *testing.T, path string, flags ...string) (*args, error) {
+	t.Helper()
+
+	commandLine := flag.NewFlagSet("test", flag.ContinueOnError)
+	defineFlags(commandLine, &args{})
+	err := commandLine.Parse(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return loadArgs(path, commandLine)
+}
+
+func TestLoadArgsFlagsOverrideConfigFile(t *testing.T) {
+	path := writeConfigFile(t,
+		"[server]",
+		`listen = ["127.0.0.1:5353"]`,
+		"max_concurrency = 16",
+		"",
+		"[resolver]",
+		`mode = "forward"`,
+		`upstreams = ["192.0.2.53:53"]`,
+		"",
+		"[rate_limit]",
+		"rate = 50",
+		"burst = 10")
+
+	a, err := loadTestArgs(t, path, "-listen", "127.0.0.1:2053,127.0.0.1:2054", "-rate-limit", "2.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"127.0.0.1:2053", "127.0.0.1:2054"}; !reflect.DeepEqual(a.listenAddresses, expected) {
+		t.Errorf("listen addresses are %q, expected %q", a.listenAddresses, expected)
+	}
+	if a.rateLimit != 2.5 {
+		t.Errorf("rate limit is %v, expected the flag's 2.5", a.rateLimit)
+	}
+
+	// Keys no flag was given for keep the value of the file
+	if a.maxConcurrency != 16 || a.rateBurst != 10 {
+		t.Errorf("max concurrency is %d and burst %d, expected 16 and 10", a.maxConcurrency, a.rateBurst)
+	}
+	if expected := []string{"192.0.2.53:53"}; !reflect.DeepEqual(a.resolverAddresses, expected) {
+		t.Errorf("upstreams are %q, expected %q", a.resolverAddresses, expected)
+	}
+
+	// Asking for recursion on the command line replaces the forwarding
+	// resolver of the file
+	a, err = loadTestArgs(t, path, "-recursive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.recursive || len(a.resolverAddresses) != 0 {
+		t.Errorf("recursive is %v with upstreams %q", a.recursive, a.resolverAddresses)
+	}
+}
+
+func TestLoadConfigFileRejectsInvalidValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		err   string
+	}{
+		{"infinite rate", []string{"[rate_limit]", "rate = inf"}, "line 2: rate_limit.rate: expected a finite number, got +Inf"},
+		{"negative infinite rate", []string{"[rate_limit]", "rate = -inf"}, "line 2: rate_limit.rate: expected a finite number, got -Inf"},
+		{"NaN sample rate", []string{"[logging]", "sample_rate = nan"}, "line 2: logging.sample_rate: expected a finite number, got NaN"},
+		{"string for a number", []string{"[rate_limit]", `burst = "10"`}, "line 2: rate_limit.burst: expected an integer"},
+		{"unknown table", []string{"[server]", "[unknown]"}, "line 2: unknown table [unknown]"},
+		{"unknown key", []string{"[server]", "port = 53"}, `line 2: unknown key "port" in [server]`},
+		{"key outside of a table", []string{"listen = []"}, `line 1: key "listen" must be in a table, e.g. [server]`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeConfigFile(t, test.lines...)
+
+			err := loadConfigFile(path, &args{})
+			if err == nil || err.Error() != path+": "+test.err {
+				t.Fatalf("error is %v, expected %q", err, test.err)
+			}
+		})
+	}
+}
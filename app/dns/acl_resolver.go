@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+type ACLOptions struct {
+	// Clients allowed to send requests. If empty, all clients are allowed
+	// unless denied.
+	Allow []*net.IPNet
+	// Clients whose requests are refused, even if they are allowed.
+	Deny []*net.IPNet
+}
+
+// Wraps another resolver to refuse requests of clients which are not allowed
+// to use it. Requests whose client is unknown, i.e. which were not received
+// from the network, are always passed on.
+type ACLResolver struct {
+	next    ContextResolver
+	options ACLOptions
+}
+
+func InitACLResolver(next ContextResolver, options ACLOptions) (*ACLResolver, error) {
+	return &ACLResolver{
+		next:    next,
+		options: options,
+	}, nil
+}
+
+func (r *ACLResolver) Resolve(request *Message) *Message {
+	return resolveWithoutContext(r, request)
+}
+
+func (r *ACLResolver) ResolveContext(ctx context.Context, info *RequestInfo, request *Message) (*Message, error) {
+	client := info.ClientIP()
+	if client != nil && !r.isAllowed(client) {
+		fmt.Println("Refusing request of client", client)
+		return MakeErrorResponse(request, RCodeRefused), nil
+	}
+
+	return r.next.ResolveContext(ctx, info, request)
+}
+
+func (r *ACLResolver) isAllowed(client net.IP) bool {
+	if containsIP(r.options.Deny, client) {
+		return false
+	}
+
+	return len(r.options.Allow) == 0 || containsIP(r.options.Allow, client)
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parses networks in CIDR notation, e.g. "192.0.2.0/24". A plain address
+// stands for a network of just that address.
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", value)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", value, err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
@@ -0,0 +1,131 @@
+package dns
+
+import "context"
+
+// Answers questions from the zones it is authoritative for. Questions about
+// names outside of its zones are passed to the fallback resolver if there is
+// one, and refused otherwise.
+type AuthoritativeResolver struct {
+	zones    []*Zone
+	fallback ContextResolver
+}
+
+// fallback may be nil, in which case questions outside of the zones are
+// refused.
+func InitAuthoritativeResolver(zones []*Zone, fallback DnsResolver) (*AuthoritativeResolver, error) {
+	resolver := &AuthoritativeResolver{zones: zones}
+	if fallback != nil {
+		resolver.fallback = AdaptResolver(fallback)
+	}
+
+	return resolver, nil
+}
+
+func (r *AuthoritativeResolver) Resolve(request *Message) *Message {
+	return resolveWithoutContext(r, request)
+}
+
+func (r *AuthoritativeResolver) ResolveContext(ctx context.Context, info *RequestInfo, request *Message) (*Message, error) {
+	answers := make([]ResourceRecord, 0)
+	authority := make([]ResourceRecord, 0)
+	additional := make([]ResourceRecord, 0)
+	isValidRequest := request.Header.Flags.OPCODE == 0
+	returnCode := RCodeNoError
+	if !isValidRequest {
+		returnCode = RCodeNotImplemented
+	}
+
+	authoritative := isValidRequest
+	recursionAvailable := false
+	if isValidRequest {
+		for _, question := range request.Questions {
+			answer, err := r.resolveQuestion(ctx, info, request, &question)
+			if err != nil {
+				return nil, err
+			}
+
+			answers = append(answers, answer.Answers...)
+			authority = append(authority, answer.Authority...)
+			additional = append(additional, answer.Additional...)
+			authoritative = authoritative && answer.Header.Flags.AA
+			recursionAvailable = recursionAvailable || answer.Header.Flags.RA
+
+			if returnCode == RCodeNoError {
+				returnCode = answer.Header.Flags.RCODE
+			}
+		}
+	}
+
+	return &Message{
+		Header: Header{
+			ID: request.Header.ID,
+			Flags: Flags{
+				QR:     true,
+				OPCODE: request.Header.Flags.OPCODE,
+				AA:     authoritative,
+				TC:     false,
+				RD:     request.Header.Flags.RD,
+				RA:     recursionAvailable,
+				Z:      0,
+				RCODE:  returnCode,
+			},
+			QDCOUNT: uint16(len(request.Questions)),
+			ANCOUNT: uint16(len(answers)),
+			NSCOUNT: uint16(len(authority)),
+			ARCOUNT: uint16(len(additional)),
+		},
+		Questions:  request.Questions,
+		Answers:    answers,
+		Authority:  authority,
+		Additional: additional,
+	}, nil
+}
+
+func (r *AuthoritativeResolver) resolveQuestion(ctx context.Context, info *RequestInfo, request *Message, question *Question) (*Message, error) {
+	zone := r.findZone(question)
+	if zone == nil {
+		if r.fallback == nil {
+			return MakeErrorResponse(request, RCodeRefused), nil
+		}
+
+		// The OPT record of the request is not passed on, the fallback only
+		// answers the question
+		fallbackRequest := questionToMessage(request.Header.ID, question)
+		fallbackRequest.Header.Flags.RD = request.Header.Flags.RD
+		fallbackRequest.SetEDNS(nil)
+
+		return r.fallback.ResolveContext(ctx, info, fallbackRequest)
+	}
+
+	answer := zone.resolve(question)
+
+	return &Message{
+		Header: Header{
+			Flags: Flags{
+				AA:    answer.authoritative,
+				RCODE: answer.returnCode,
+			},
+		},
+		Answers:    answer.answers,
+		Authority:  answer.authority,
+		Additional: answer.additional,
+	}, nil
+}
+
+// Returns the zone closest to the name in question, i.e. the zone with the
+// longest origin the name is within, or nil if no zone contains it.
+func (r *AuthoritativeResolver) findZone(question *Question) *Zone {
+	var closest *Zone
+
+	for _, zone := range r.zones {
+		if !zone.contains(question) {
+			continue
+		}
+
+		if closest == nil || len(zone.Origin.Labels) > len(closest.Origin.Labels) {
+			closest = zone
+		}
+	}
+
+	return closest
+}
@@ -0,0 +1,158 @@
+package dns
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Identifies an RRset: all records with the same owner name, type and class.
+type rrSetKey struct {
+	// The lower-cased owner name, as names compare case-insensitively.
+	name    string
+	rrType  ResourceRecordType
+	rrClass ResourceRecordClass
+	// Negative entries record that the name or RRset doesn't exist (RFC
+	// 2308). They hold the SOA record of the zone, which proves it.
+	negative bool
+}
+
+// The type of the key under which a non-existent name is cached. Type 0 is
+// reserved, so it can't collide with a key for NODATA.
+const nxDomainKeyType ResourceRecordType = 0
+
+func makeRRSetKey(name *DomainName, rrType ResourceRecordType, rrClass ResourceRecordClass) rrSetKey {
+	return rrSetKey{
+		name:    nameKey(name.Labels),
+		rrType:  rrType,
+		rrClass: rrClass,
+	}
+}
+
+// Splits the records into RRsets, in the order their first record appears.
+func groupRRSets(records []ResourceRecord) [][]ResourceRecord {
+	rrSets := make([][]ResourceRecord, 0)
+	indices := make(map[rrSetKey]int)
+
+	for _, record := range records {
+		key := makeRRSetKey(&record.Name, record.Type, record.Class)
+
+		index, ok := indices[key]
+		if !ok {
+			index = len(rrSets)
+			indices[key] = index
+			rrSets = append(rrSets, make([]ResourceRecord, 0, 1))
+		}
+
+		rrSets[index] = append(rrSets[index], record)
+	}
+
+	return rrSets
+}
+
+// The key of a cached NXDOMAIN: the name doesn't exist, with any type.
+func makeNXDomainKey(name *DomainName, rrClass ResourceRecordClass) rrSetKey {
+	key := makeRRSetKey(name, nxDomainKeyType, rrClass)
+	key.negative = true
+	return key
+}
+
+// The key of a cached NODATA: the name exists, but has no RRset of the type.
+func makeNoDataKey(name *DomainName, rrType ResourceRecordType, rrClass ResourceRecordClass) rrSetKey {
+	key := makeRRSetKey(name, rrType, rrClass)
+	key.negative = true
+	return key
+}
+
+type cacheEntry struct {
+	key     rrSetKey
+	records []ResourceRecord
+	expires time.Time
+}
+
+// A size bounded cache of RRsets. Once full, the least recently used RRset is
+// evicted to make room for a new one. It is safe for concurrent use.
+type rrSetCache struct {
+	mutex      sync.Mutex
+	maxEntries int
+	// Entries ordered from the most to the least recently used.
+	lru     *list.List
+	entries map[rrSetKey]*list.Element
+}
+
+func newRRSetCache(maxEntries int) *rrSetCache {
+	return &rrSetCache{
+		maxEntries: maxEntries,
+		lru:        list.New(),
+		entries:    make(map[rrSetKey]*list.Element),
+	}
+}
+
+// Returns a copy of the cached RRset with the TTLs counted down to the time
+// that remains until it expires.
+func (c *rrSetCache) get(key rrSetKey, now time.Time) ([]ResourceRecord, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	remaining := entry.expires.Sub(now)
+	if remaining <= 0 {
+		c.removeElement(element)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+
+	// Rounded up, so that a fresh entry keeps its original TTL
+	ttl := uint32((remaining + time.Second - 1) / time.Second)
+	records := make([]ResourceRecord, len(entry.records))
+	for i, record := range entry.records {
+		records[i] = record
+		records[i].TTL = ttl
+	}
+
+	return records, true
+}
+
+func (c *rrSetCache) put(key rrSetKey, records []ResourceRecord, ttl time.Duration, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry := &cacheEntry{
+		key:     key,
+		records: records,
+		expires: now.Add(ttl),
+	}
+
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.maxEntries {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+func (c *rrSetCache) removeElement(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.lru.Remove(element)
+}
+
+// The number of RRsets in the cache, including expired ones which haven't
+// been evicted yet.
+func (c *rrSetCache) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.lru.Len()
+}
@@ -0,0 +1,369 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+type CacheOptions struct {
+	// The maximum number of RRsets kept in the cache.
+	MaxEntries int
+	// Records are cached for at least this many seconds...
+	MinTTL uint32
+	// ...and at most this many seconds, regardless of their TTL.
+	MaxTTL uint32
+}
+
+type CacheStats struct {
+	// Requests answered from the cache.
+	Hits uint64
+	// Requests passed on to the wrapped resolver.
+	Misses uint64
+	// The number of RRsets in the cache.
+	Size int
+}
+
+// Wraps another resolver to cache the RRsets it answers with, as well as its
+// answers that a name or RRset doesn't exist. Requests whose questions can all
+// be answered from the cache are not passed on. The TTLs of
+// cached records count down, so that clients don't keep records for longer
+// than the resolver that returned them meant to.
+type CachingResolver struct {
+	next    ContextResolver
+	options CacheOptions
+	cache   *rrSetCache
+
+	// Shared with the resolvers that take over the cache, like the cache
+	// itself, so the counts survive reloads.
+	counters *cacheCounters
+
+	// Returns the current time, replaced by tests.
+	now func() time.Time
+}
+
+func InitCachingResolver(next DnsResolver, options CacheOptions) (*CachingResolver, error) {
+	if options.MaxEntries < 1 {
+		return nil, fmt.Errorf("cache must hold at least 1 entry, got %d", options.MaxEntries)
+	}
+
+	if options.MinTTL > options.MaxTTL {
+		return nil, fmt.Errorf("minimum cache TTL %d exceeds maximum of %d", options.MinTTL, options.MaxTTL)
+	}
+
+	return &CachingResolver{
+		next:     AdaptResolver(next),
+		options:  options,
+		cache:    newRRSetCache(options.MaxEntries),
+		counters: &cacheCounters{},
+		now:      time.Now,
+	}, nil
+}
+
+func (r *CachingResolver) Resolve(request *Message) *Message {
+	return resolveWithoutContext(r, request)
+}
+
+func (r *CachingResolver) ResolveContext(ctx context.Context, info *RequestInfo, request *Message) (*Message, error) {
+	if request.Header.Flags.OPCODE != OpcodeQuery || len(request.Questions) == 0 {
+		return r.next.ResolveContext(ctx, info, request)
+	}
+
+	now := r.now()
+	answers := make([]ResourceRecord, 0)
+	authority := make([]ResourceRecord, 0)
+	returnCode := RCodeNoError
+	for _, question := range request.Questions {
+		cached, ok := r.lookup(&question, now)
+		if !ok {
+			r.counters.misses.Add(1)
+
+			response, err := r.next.ResolveContext(ctx, info, request)
+			if err != nil {
+				return nil, err
+			}
+
+			r.store(request, response, now)
+			return response, nil
+		}
+
+		answers = append(answers, cached.answers...)
+		authority = append(authority, cached.authority...)
+		if returnCode == RCodeNoError {
+			returnCode = cached.returnCode
+		}
+	}
+
+	r.counters.hits.Add(1)
+	info.CacheHit = true
+
+	return &Message{
+		Header: Header{
+			ID: request.Header.ID,
+			Flags: Flags{
+				QR:     true,
+				OPCODE: request.Header.Flags.OPCODE,
+				AA:     false,
+				TC:     false,
+				RD:     request.Header.Flags.RD,
+				RA:     false,
+				Z:      0,
+				RCODE:  returnCode,
+			},
+			QDCOUNT: uint16(len(request.Questions)),
+			ANCOUNT: uint16(len(answers)),
+			NSCOUNT: uint16(len(authority)),
+			ARCOUNT: 0,
+		},
+		Questions: request.Questions,
+		Answers:   answers,
+		Authority: authority,
+	}, nil
+}
+
+// Shares the cache of the previous resolver, so that its records survive the
+// previous resolver being replaced, e.g. when the configuration is reloaded.
+// Only caches of the same size can be shared, but the hit and miss counts
+// are carried over either way.
+func (r *CachingResolver) TakeOverCache(previous *CachingResolver) error {
+	r.counters = previous.counters
+
+	if previous.options.MaxEntries != r.options.MaxEntries {
+		return fmt.Errorf("cache size changed from %d to %d", previous.options.MaxEntries, r.options.MaxEntries)
+	}
+
+	r.cache = previous.cache
+	return nil
+}
+
+func (r *CachingResolver) Stats() CacheStats {
+	return CacheStats{
+		Hits:   r.counters.hits.Load(),
+		Misses: r.counters.misses.Load(),
+		Size:   r.cache.len(),
+	}
+}
+
+// How many requests were answered from the cache, and how many were not.
+type cacheCounters struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// The answer to a single question, as found in the cache.
+type cachedAnswer struct {
+	answers []ResourceRecord
+	// The SOA record proving a negative answer.
+	authority  []ResourceRecord
+	returnCode ResponseCode
+}
+
+// Answers the question from the cache, following any CNAME chain that leads
+// to the requested RRset. The answer may also be that the name or the RRset
+// doesn't exist.
+func (r *CachingResolver) lookup(question *Question, now time.Time) (*cachedAnswer, bool) {
+	answers := make([]ResourceRecord, 0)
+	name := question.Name
+
+	for i := 0; i <= MAX_CNAME_CHAIN; i++ {
+		soa, ok := r.cache.get(makeNXDomainKey(&name, question.Class), now)
+		if ok {
+			return &cachedAnswer{answers: answers, authority: soa, returnCode: RCodeNameError}, true
+		}
+
+		records, ok := r.cache.get(makeRRSetKey(&name, question.Type, question.Class), now)
+		if ok {
+			return &cachedAnswer{answers: append(answers, records...), returnCode: RCodeNoError}, true
+		}
+
+		soa, ok = r.cache.get(makeNoDataKey(&name, question.Type, question.Class), now)
+		if ok {
+			return &cachedAnswer{answers: answers, authority: soa, returnCode: RCodeNoError}, true
+		}
+
+		if question.Type == TYPE_CNAME {
+			return nil, false
+		}
+
+		cnames, ok := r.cache.get(makeRRSetKey(&name, TYPE_CNAME, question.Class), now)
+		if !ok || len(cnames) != 1 {
+			return nil, false
+		}
+
+		cname, ok := cnames[0].RData.(*CNAMEData)
+		if !ok {
+			return nil, false
+		}
+
+		answers = append(answers, cnames...)
+		name = cname.Target
+	}
+
+	return nil, false
+}
+
+// Caches the RRsets of the answer section of a response, and whether the
+// response says that the name or RRset asked for doesn't exist. Only RRsets
+// owned by the names asked for, or by the CNAME chains starting at them, are
+// cached: other records don't answer the question, and caching them would
+// let a misbehaving upstream overwrite the cached records of any name.
+func (r *CachingResolver) store(request *Message, response *Message, now time.Time) {
+	if !response.Header.Flags.QR || response.Header.Flags.TC {
+		return
+	}
+
+	returnCode := response.Header.Flags.RCODE
+	if returnCode != RCodeNoError && returnCode != RCodeNameError {
+		return
+	}
+
+	owners := make(map[string]bool)
+	for i := range request.Questions {
+		question := &request.Questions[i]
+		for _, name := range cnameChain(response.Answers, &question.Name, question.Class) {
+			owners[nameKey(name.Labels)] = true
+		}
+	}
+
+	for _, rrSet := range groupRRSets(response.Answers) {
+		if !owners[nameKey(rrSet[0].Name.Labels)] {
+			continue
+		}
+
+		ttl, ok := r.cacheTTL(rrSet)
+		if !ok {
+			continue
+		}
+
+		first := &rrSet[0]
+		r.cache.put(makeRRSetKey(&first.Name, first.Type, first.Class), rrSet, ttl, now)
+	}
+
+	// A negative answer can only be attributed to a question if there is
+	// just one
+	if len(request.Questions) == 1 {
+		r.storeNegative(&request.Questions[0], response, now)
+	}
+}
+
+// Caches a NXDOMAIN or NODATA answer to the question. Either is only cached
+// if the response includes the SOA record of the zone, whose TTL and MINIMUM
+// say for how long the answer may be cached (RFC 2308 section 5).
+func (r *CachingResolver) storeNegative(question *Question, response *Message, now time.Time) {
+	var soa *ResourceRecord
+	for i, record := range response.Authority {
+		if record.Type == TYPE_SOA {
+			soa = &response.Authority[i]
+			break
+		}
+	}
+
+	if soa == nil {
+		return
+	}
+
+	soaData, ok := soa.RData.(*SOAData)
+	if !ok {
+		return
+	}
+
+	// The answer is about the end of the CNAME chain within the response
+	name := followCNAMEs(response.Answers, &question.Name, question.Class)
+
+	var key rrSetKey
+	switch response.Header.Flags.RCODE {
+	case RCodeNameError:
+		key = makeNXDomainKey(name, question.Class)
+	case RCodeNoError:
+		for _, record := range response.Answers {
+			if record.Type == question.Type && record.Class == question.Class && record.Name.Equal(name) {
+				return
+			}
+		}
+
+		key = makeNoDataKey(name, question.Type, question.Class)
+	default:
+		return
+	}
+
+	negativeSOA := *soa
+	if soaData.Minimum < negativeSOA.TTL {
+		negativeSOA.TTL = soaData.Minimum
+	}
+
+	ttl, ok := r.cacheTTL([]ResourceRecord{negativeSOA})
+	if !ok {
+		return
+	}
+
+	r.cache.put(key, []ResourceRecord{negativeSOA}, ttl, now)
+}
+
+// Follows the CNAME chain starting at name through the records, and returns
+// the name at its end.
+func followCNAMEs(records []ResourceRecord, name *DomainName, rrClass ResourceRecordClass) *DomainName {
+	chain := cnameChain(records, name, rrClass)
+	return chain[len(chain)-1]
+}
+
+// Returns the names of the CNAME chain starting at name through the records,
+// starting with name itself. A chain which loops ends before the first name
+// it would visit again.
+func cnameChain(records []ResourceRecord, name *DomainName, rrClass ResourceRecordClass) []*DomainName {
+	chain := []*DomainName{name}
+	visited := map[string]bool{nameKey(name.Labels): true}
+
+	for i := 0; i < MAX_CNAME_CHAIN; i++ {
+		next := findCNAME(records, chain[len(chain)-1], rrClass)
+		if next == nil || visited[nameKey(next.Labels)] {
+			break
+		}
+
+		chain = append(chain, next)
+		visited[nameKey(next.Labels)] = true
+	}
+
+	return chain
+}
+
+// Returns the target of the CNAME record owned by name, or nil if there is
+// none.
+func findCNAME(records []ResourceRecord, name *DomainName, rrClass ResourceRecordClass) *DomainName {
+	for _, record := range records {
+		if record.Type != TYPE_CNAME || record.Class != rrClass || !record.Name.Equal(name) {
+			continue
+		}
+
+		if cname, ok := record.RData.(*CNAMEData); ok {
+			return &cname.Target
+		}
+	}
+
+	return nil
+}
+
+// Returns how long the RRset may be cached: the lowest TTL of its records,
+// clamped to the configured bounds. Records with a TTL of zero must not be
+// cached at all (RFC 1035 section 3.2.1).
+func (r *CachingResolver) cacheTTL(rrSet []ResourceRecord) (time.Duration, bool) {
+	ttl := rrSet[0].TTL
+	for _, record := range rrSet[1:] {
+		if record.TTL < ttl {
+			ttl = record.TTL
+		}
+	}
+
+	if ttl == 0 {
+		return 0, false
+	}
+
+	if ttl < r.options.MinTTL {
+		ttl = r.options.MinTTL
+	}
+	if ttl > r.options.MaxTTL {
+		ttl = r.options.MaxTTL
+	}
+
+	return time.Duration(ttl) * time.Second, true
+}
@@ -0,0 +1,210 @@
+package dns
+
+import (
+	"testing"
+	"time"
+)
+
+// Answers every request with the records the test set for the question's
+// name, and counts the requests it received.
+type stubResolver struct {
+	records  map[string][]ResourceRecord
+	requests int
+}
+
+func (r *stubResolver) Resolve(request *Message) *Message {
+	r.requests++
+
+	response := MakeErrorResponse(request, RCodeNoError)
+	response.Answers = r.records[request.Questions[0].Name.String()]
+	response.Header.ANCOUNT = uint16(len(response.Answers))
+
+	return response
+}
+
+// A caching resolver whose clock only moves when the test advances it.
+type cacheTest struct {
+	t        *testing.T
+	resolver *CachingResolver
+	next     *stubResolver
+	now      time.Time
+}
+
+func newCacheTest(t *testing.T, options CacheOptions, records ...string) *cacheTest {
+	next := &stubResolver{records: make(map[string][]ResourceRecord)}
+	for _, record := range parseRecords(t, records...) {
+		name := record.Name.String()
+		next.records[name] = append(next.records[name], record)
+	}
+
+	resolver, err := InitCachingResolver(next, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &cacheTest{t: t, resolver: resolver, next: next, now: time.Unix(1700000000, 0)}
+	resolver.now = func() time.Time { return c.now }
+
+	return c
+}
+
+func (c *cacheTest) advance(seconds int) {
+	c.now = c.now.Add(time.Duration(seconds) * time.Second)
+}
+
+// Resolves the question and checks whether it was answered from the cache.
+// Returns the answers.
+func (c *cacheTest) resolve(name string, rrType ResourceRecordType, cached bool) []ResourceRecord {
+	c.t.Helper()
+
+	qname, err := ParseDomainName(name, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	request := &Message{
+		Header:    Header{ID: 1, QDCOUNT: 1, Flags: Flags{RD: true}},
+		Questions: []Question{{Name: *qname, Type: rrType, Class: CLASS_IN}},
+	}
+
+	requests := c.next.requests
+	response := c.resolver.Resolve(request)
+	if response == nil {
+		c.t.Fatalf("no response for %s", name)
+	}
+
+	if hit := c.next.requests == requests; hit != cached {
+		c.t.Fatalf("%s %v: cached is %v, expected %v", name, rrType, hit, cached)
+	}
+
+	return response.Answers
+}
+
+func (c *cacheTest) expectTTL(answers []ResourceRecord, ttl uint32) {
+	c.t.Helper()
+
+	if len(answers) == 0 {
+		c.t.Fatal("no answers")
+	}
+
+	for _, record := range answers {
+		if record.TTL != ttl {
+			c.t.Errorf("TTL of %s is %d, expected %d", record.String(), record.TTL, ttl)
+		}
+	}
+}
+
+func TestCachingResolverCountsDownTTLs(t *testing.T) {
+	c := newCacheTest(t, CacheOptions{MaxEntries: 10, MaxTTL: 86400},
+		"www.example.com. 300 IN A 192.0.2.1",
+		"www.example.com. 300 IN A 192.0.2.2")
+
+	c.expectTTL(c.resolve("www.example.com.", TYPE_A, false), 300)
+	c.expectTTL(c.resolve("www.example.com.", TYPE_A, true), 300)
+
+	c.advance(100)
+	c.expectTTL(c.resolve("www.example.com.", TYPE_A, true), 200)
+
+	c.advance(199)
+	c.expectTTL(c.resolve("www.example.com.", TYPE_A, true), 1)
+
+	c.advance(1)
+	c.expectTTL(c.resolve("www.example.com.", TYPE_A, false), 300)
+}
+
+func TestCachingResolverClampsTTLs(t *testing.T) {
+	c := newCacheTest(t, CacheOptions{MaxEntries: 10, MinTTL: 60, MaxTTL: 3600},
+		"short.example.com. 10 IN A 192.0.2.1",
+		"long.example.com. 86400 IN A 192.0.2.2",
+		"zero.example.com. 0 IN A 192.0.2.3")
+
+	c.resolve("short.example.com.", TYPE_A, false)
+	c.advance(30)
+	c.expectTTL(c.resolve("short.example.com.", TYPE_A, true), 30)
+	c.advance(30)
+	c.resolve("short.example.com.", TYPE_A, false)
+
+	c.resolve("long.example.com.", TYPE_A, false)
+	c.expectTTL(c.resolve("long.example.com.", TYPE_A, true), 3600)
+	c.advance(3600)
+	c.resolve("long.example.com.", TYPE_A, false)
+
+	// Records with a TTL of zero are never cached, regardless of MinTTL
+	c.resolve("zero.example.com.", TYPE_A, false)
+	c.resolve("zero.example.com.", TYPE_A, false)
+}
+
+func TestCachingResolverEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newCacheTest(t, CacheOptions{MaxEntries: 2, MaxTTL: 86400},
+		"a.example.com. 300 IN A 192.0.2.1",
+		"b.example.com. 300 IN A 192.0.2.2",
+		"c.example.com. 300 IN A 192.0.2.3")
+
+	c.resolve("a.example.com.", TYPE_A, false)
+	c.resolve("b.example.com.", TYPE_A, false)
+
+	// a becomes the most recently used, so b is evicted to make room for c
+	c.resolve("a.example.com.", TYPE_A, true)
+	c.resolve("c.example.com.", TYPE_A, false)
+
+	if size := c.resolver.Stats().Size; size != 2 {
+		t.Errorf("cache holds %d RRsets", size)
+	}
+
+	c.resolve("a.example.com.", TYPE_A, true)
+	c.resolve("c.example.com.", TYPE_A, true)
+	c.resolve("b.example.com.", TYPE_A, false)
+}
+
+func TestCachingResolverOnlyCachesRecordsOfTheQuestion(t *testing.T) {
+	c := newCacheTest(t, CacheOptions{MaxEntries: 10, MaxTTL: 86400})
+
+	// Besides the CNAME chain, the answer holds a record of an unrelated
+	// name, as a misbehaving upstream might add
+	c.next.records["www.example.com."] = parseRecords(t,
+		"www.example.com. 300 IN CNAME web.example.com.",
+		"web.example.com. 300 IN A 192.0.2.1",
+		"victim.example.org. 300 IN A 198.51.100.1")
+	c.next.records["victim.example.org."] = parseRecords(t,
+		"victim.example.org. 300 IN A 192.0.2.2")
+
+	c.resolve("www.example.com.", TYPE_A, false)
+	c.resolve("www.example.com.", TYPE_A, true)
+	c.resolve("web.example.com.", TYPE_A, true)
+
+	answers := c.resolve("victim.example.org.", TYPE_A, false)
+	if len(answers) != 1 || answers[0].String() != "victim.example.org. 300 IN A 192.0.2.2" {
+		t.Errorf("answers are %v", answers)
+	}
+}
+
+func TestCachingResolverTakeOverCacheKeepsCounts(t *testing.T) {
+	previous := newCacheTest(t, CacheOptions{MaxEntries: 10, MaxTTL: 86400},
+		"a.example.com. 300 IN A 192.0.2.1")
+	previous.resolve("a.example.com.", TYPE_A, false)
+	previous.resolve("a.example.com.", TYPE_A, true)
+
+	c := newCacheTest(t, CacheOptions{MaxEntries: 10, MaxTTL: 86400})
+	if err := c.resolver.TakeOverCache(previous.resolver); err != nil {
+		t.Fatal(err)
+	}
+
+	c.now = previous.now
+	c.resolve("a.example.com.", TYPE_A, true)
+
+	expected := CacheStats{Hits: 2, Misses: 1, Size: 1}
+	if stats := c.resolver.Stats(); stats != expected {
+		t.Errorf("stats are %+v, expected %+v", stats, expected)
+	}
+
+	// A cache of another size starts empty, but keeps counting
+	resized := newCacheTest(t, CacheOptions{MaxEntries: 20, MaxTTL: 86400})
+	if err := resized.resolver.TakeOverCache(c.resolver); err == nil {
+		t.Fatal("cache of another size was taken over")
+	}
+
+	expected = CacheStats{Hits: 2, Misses: 1, Size: 0}
+	if stats := resized.resolver.Stats(); stats != expected {
+		t.Errorf("stats are %+v, expected %+v", stats, expected)
+	}
+}
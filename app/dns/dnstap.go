@@ -0,0 +1,384 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The content type of dnstap data frames.
+const DNSTAP_CONTENT_TYPE = "protobuf:dnstap.Dnstap"
+
+// How many messages may wait to be written before further ones are dropped.
+const DNSTAP_QUEUE_SIZE = 1024
+
+// How long to wait before connecting to the socket again after failing.
+const DNSTAP_RECONNECT_INTERVAL = 5 * time.Second
+
+// How long the reader may take to complete the handshake, or to accept
+// written frames.
+const DNSTAP_SOCKET_TIMEOUT = 5 * time.Second
+
+// The types of messages logged via dnstap, as defined by dnstap.proto.
+type DnstapMessageType uint32
+
+const (
+	DnstapClientQuery       DnstapMessageType = 5
+	DnstapClientResponse    DnstapMessageType = 6
+	DnstapForwarderQuery    DnstapMessageType = 7
+	DnstapForwarderResponse DnstapMessageType = 8
+)
+
+// Field numbers and values of dnstap.proto.
+const (
+	dnstapFieldIdentity = 1
+	dnstapFieldMessage  = 14
+	dnstapFieldType     = 15
+	// Dnstap.Type MESSAGE
+	dnstapTypeMessage = 1
+
+	messageFieldType             = 1
+	messageFieldSocketFamily     = 2
+	messageFieldSocketProtocol   = 3
+	messageFieldQueryAddress     = 4
+	messageFieldResponseAddress  = 5
+	messageFieldQueryPort        = 6
+	messageFieldResponsePort     = 7
+	messageFieldQueryTimeSec     = 8
+	messageFieldQueryTimeNsec    = 9
+	messageFieldQueryMessage     = 10
+	messageFieldResponseTimeSec  = 12
+	messageFieldResponseTimeNsec = 13
+	messageFieldResponseMessage  = 14
+	socketFamilyINET             = 1
+	socketFamilyINET6            = 2
+	socketProtocolUDP            = 1
+	socketProtocolTCP            = 2
+)
+
+type DnstapOptions struct {
+	// The Unix socket of a dnstap reader to connect to. Exactly one of
+	// SocketPath and FilePath must be set.
+	SocketPath string
+	// The file to write the messages to, which is truncated first.
+	FilePath string
+	// Identifies this server in every message, omitted if empty.
+	Identity string
+}
+
+// A DNS message exchanged with a client or an upstream.
+type DnstapMessage struct {
+	Type      DnstapMessageType
+	Transport Transport
+	// The address the query was sent from and the address it was sent to,
+	// which the response is sent from.
+	QueryAddr    net.Addr
+	ResponseAddr net.Addr
+	// The query on the wire and when it was sent, set for query messages.
+	QueryTime time.Time
+	Query     []byte
+	// The response on the wire and when it was sent, set for response
+	// messages.
+	ResponseTime time.Time
+	Response     []byte
+}
+
+// Writes DNS messages in dnstap format, as protobuf messages carried by a
+// Frame Streams connection to a Unix socket, or by a Frame Streams file.
+//
+// Messages are queued and written in the background, so that a slow reader
+// never delays responses. When the queue is full, or while the socket can't
+// be connected to, messages are dropped.
+type DnstapWriter struct {
+	options DnstapOptions
+	frames  chan []byte
+	dropped atomic.Uint64
+
+	// Guards frames against being written to once it is closed.
+	mutex  sync.RWMutex
+	closed bool
+	// Closed once the background writer is done.
+	stopped chan struct{}
+}
+
+func InitDnstapWriter(options DnstapOptions) (*DnstapWriter, error) {
+	if (options.SocketPath == "") == (options.FilePath == "") {
+		return nil, fmt.Errorf("dnstap needs either a socket or a file")
+	}
+
+	w := &DnstapWriter{
+		options: options,
+		frames:  make(chan []byte, DNSTAP_QUEUE_SIZE),
+		stopped: make(chan struct{}),
+	}
+
+	if options.FilePath != "" {
+		// Opened right away, so that errors are reported at startup
+		file, err := os.Create(options.FilePath)
+		if err != nil {
+			return nil, err
+		}
+
+		go w.writeFile(file)
+	} else {
+		// The reader may be started after the server, so the socket is only
+		// connected to once there are messages
+		go w.writeSocket()
+	}
+
+	return w, nil
+}
+
+// Queues the message to be written.
+func (w *DnstapWriter) Write(message *DnstapMessage) {
+	frame := w.encode(message)
+
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.frames <- frame:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// The number of messages dropped so far.
+func (w *DnstapWriter) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+// Writes the queued messages and ends the stream. Further messages are
+// discarded.
+func (w *DnstapWriter) Close() {
+	w.mutex.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.frames)
+	}
+	w.mutex.Unlock()
+
+	<-w.stopped
+}
+
+// Writes a unidirectional Frame Streams file: START, the data frames, STOP.
+func (w *DnstapWriter) writeFile(file *os.File) {
+	defer close(w.stopped)
+	defer file.Close()
+
+	buf := bufio.NewWriter(file)
+	err := writeControlFrame(buf, FSTRM_CONTROL_START, DNSTAP_CONTENT_TYPE)
+
+	for frame := range w.frames {
+		if err != nil {
+			w.dropped.Add(1)
+			continue
+		}
+
+		err = writeDataFrame(buf, frame)
+		if err == nil && len(w.frames) == 0 {
+			err = buf.Flush()
+		}
+		if err != nil {
+			fmt.Println("Failed to write dnstap file:", err)
+		}
+	}
+
+	if err == nil {
+		err = writeControlFrame(buf, FSTRM_CONTROL_STOP)
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		fmt.Println("Failed to finish dnstap file:", err)
+	}
+}
+
+// Writes the data frames over a bidirectional Frame Streams connection,
+// reconnecting when the connection fails.
+func (w *DnstapWriter) writeSocket() {
+	defer close(w.stopped)
+
+	var conn net.Conn
+	var buf *bufio.Writer
+	var lastAttempt time.Time
+
+	for frame := range w.frames {
+		if conn == nil {
+			if !lastAttempt.IsZero() && time.Since(lastAttempt) < DNSTAP_RECONNECT_INTERVAL {
+				w.dropped.Add(1)
+				continue
+			}
+
+			lastAttempt = time.Now()
+			var err error
+			conn, err = w.connect()
+			if err != nil {
+				fmt.Println("Failed to connect to dnstap socket:", err)
+				w.dropped.Add(1)
+				continue
+			}
+			buf = bufio.NewWriter(conn)
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(DNSTAP_SOCKET_TIMEOUT))
+		err := writeDataFrame(buf, frame)
+		if err == nil && len(w.frames) == 0 {
+			err = buf.Flush()
+		}
+		if err != nil {
+			fmt.Println("Failed to write to dnstap socket:", err)
+			conn.Close()
+			conn = nil
+		}
+	}
+
+	if conn != nil {
+		conn.SetDeadline(time.Now().Add(DNSTAP_SOCKET_TIMEOUT))
+		err := stopFrameStream(conn, buf)
+		if err != nil {
+			fmt.Println("Failed to finish dnstap stream:", err)
+		}
+		conn.Close()
+	}
+}
+
+// Connects to the reader and starts the stream.
+func (w *DnstapWriter) connect() (net.Conn, error) {
+	conn, err := net.DialTimeout("unix", w.options.SocketPath, DNSTAP_SOCKET_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(DNSTAP_SOCKET_TIMEOUT))
+
+	err = startFrameStream(conn, DNSTAP_CONTENT_TYPE)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// Encodes the message as a Dnstap protobuf message.
+func (w *DnstapWriter) encode(m *DnstapMessage) []byte {
+	message := appendProtoVarint(nil, messageFieldType, uint64(m.Type))
+
+	protocol := socketProtocolUDP
+	if m.Transport == TransportTCP {
+		protocol = socketProtocolTCP
+	}
+	message = appendProtoVarint(message, messageFieldSocketProtocol, uint64(protocol))
+
+	queryIP, queryPort, hasQueryAddr := splitAddr(m.QueryAddr)
+	responseIP, responsePort, hasResponseAddr := splitAddr(m.ResponseAddr)
+
+	switch {
+	case hasQueryAddr:
+		message = appendSocketFamily(message, queryIP)
+	case hasResponseAddr:
+		message = appendSocketFamily(message, responseIP)
+	}
+
+	if hasQueryAddr {
+		message = appendProtoBytes(message, messageFieldQueryAddress, queryIP)
+		message = appendProtoVarint(message, messageFieldQueryPort, uint64(queryPort))
+	}
+
+	if hasResponseAddr {
+		message = appendProtoBytes(message, messageFieldResponseAddress, responseIP)
+		message = appendProtoVarint(message, messageFieldResponsePort, uint64(responsePort))
+	}
+
+	if m.Query != nil {
+		message = appendProtoVarint(message, messageFieldQueryTimeSec, uint64(m.QueryTime.Unix()))
+		message = appendProtoFixed32(message, messageFieldQueryTimeNsec, uint32(m.QueryTime.Nanosecond()))
+		message = appendProtoBytes(message, messageFieldQueryMessage, m.Query)
+	}
+
+	if m.Response != nil {
+		message = appendProtoVarint(message, messageFieldResponseTimeSec, uint64(m.ResponseTime.Unix()))
+		message = appendProtoFixed32(message, messageFieldResponseTimeNsec, uint32(m.ResponseTime.Nanosecond()))
+		message = appendProtoBytes(message, messageFieldResponseMessage, m.Response)
+	}
+
+	frame := make([]byte, 0, len(message)+64)
+	if w.options.Identity != "" {
+		frame = appendProtoBytes(frame, dnstapFieldIdentity, []byte(w.options.Identity))
+	}
+	frame = appendProtoBytes(frame, dnstapFieldMessage, message)
+	frame = appendProtoVarint(frame, dnstapFieldType, dnstapTypeMessage)
+
+	return frame
+}
+
+func appendSocketFamily(message []byte, ip net.IP) []byte {
+	family := socketFamilyINET6
+	if len(ip) == net.IPv4len {
+		family = socketFamilyINET
+	}
+
+	return appendProtoVarint(message, messageFieldSocketFamily, uint64(family))
+}
+
+// Returns the IP address, in its 4 octet form for IPv4, and the port of a
+// UDP or TCP address. Unspecified addresses, e.g. of sockets bound to all
+// interfaces, are left out, as they don't say which family was used.
+func splitAddr(addr net.Addr) (net.IP, int, bool) {
+	var ip net.IP
+	var port int
+
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		ip, port = addr.IP, addr.Port
+	case *net.TCPAddr:
+		ip, port = addr.IP, addr.Port
+	default:
+		return nil, 0, false
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	return ip, port, ip != nil && !ip.IsUnspecified()
+}
+
+// Protobuf wire types (https://protobuf.dev/programming-guides/encoding/).
+const (
+	protoWireVarint  = 0
+	protoWireBytes   = 2
+	protoWireFixed32 = 5
+)
+
+func appendProtoTag(buf []byte, field int, wireType int) []byte {
+	return binary.AppendUvarint(buf, uint64(field)<<3|uint64(wireType))
+}
+
+func appendProtoVarint(buf []byte, field int, value uint64) []byte {
+	buf = appendProtoTag(buf, field, protoWireVarint)
+	return binary.AppendUvarint(buf, value)
+}
+
+func appendProtoBytes(buf []byte, field int, value []byte) []byte {
+	buf = appendProtoTag(buf, field, protoWireBytes)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+func appendProtoFixed32(buf []byte, field int, value uint32) []byte {
+	buf = appendProtoTag(buf, field, protoWireFixed32)
+	return binary.LittleEndian.AppendUint32(buf, value)
+}
@@ -0,0 +1,281 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Reads a single frame. Returns the data of data frames, or the type of
+// control frames.
+func readFrame(r io.Reader) ([]byte, uint32, error) {
+	header := make([]byte, 4)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	if length == 0 {
+		// The escape is part of the control frame header
+		controlType, _, err := readControlFrame(io.MultiReader(bytes.NewReader(header), r))
+		return nil, controlType, err
+	}
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(r, data)
+	return data, 0, err
+}
+
+// The fields of a protobuf message, by field number. Varint and fixed32
+// values are kept as numbers, length-delimited values as bytes.
+type protoFields struct {
+	numbers map[int]uint64
+	bytes   map[int][]byte
+}
+
+func decodeProto(t *testing.T, buf []byte) protoFields {
+	fields := protoFields{numbers: make(map[int]uint64), bytes: make(map[int][]byte)}
+
+	for len(buf) > 0 {
+		tag, n := binary.Uvarint(buf)
+		if n <= 0 {
+			t.Fatalf("invalid tag")
+		}
+		buf = buf[n:]
+		field := int(tag >> 3)
+
+		switch tag & 0x07 {
+		case protoWireVarint:
+			value, n := binary.Uvarint(buf)
+			if n <= 0 {
+				t.Fatalf("invalid varint in field %d", field)
+			}
+			fields.numbers[field] = value
+			buf = buf[n:]
+		case protoWireFixed32:
+			if len(buf) < 4 {
+				t.Fatalf("truncated fixed32 in field %d", field)
+			}
+			fields.numbers[field] = uint64(binary.LittleEndian.Uint32(buf))
+			buf = buf[4:]
+		case protoWireBytes:
+			length, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < length {
+				t.Fatalf("truncated bytes in field %d", field)
+			}
+			fields.bytes[field] = buf[n : n+int(length)]
+			buf = buf[n+int(length):]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&0x07)
+		}
+	}
+
+	return fields
+}
+
+func testDnstapMessages() []*DnstapMessage {
+	client := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5353}
+	server := &net.UDPAddr{IP: net.ParseIP("2001:db8::53"), Port: 53}
+
+	return []*DnstapMessage{
+		{
+			Type:         DnstapClientQuery,
+			Transport:    TransportUDP,
+			QueryAddr:    client,
+			ResponseAddr: client,
+			QueryTime:    time.Unix(1700000000, 123),
+			Query:        []byte("query"),
+		},
+		{
+			Type:         DnstapForwarderResponse,
+			Transport:    TransportTCP,
+			QueryAddr:    server,
+			ResponseAddr: server,
+			ResponseTime: time.Unix(1700000001, 456),
+			Response:     []byte("response"),
+		},
+	}
+}
+
+func checkDnstapFrame(t *testing.T, frame []byte, expected *DnstapMessage) {
+	dnstap := decodeProto(t, frame)
+	if string(dnstap.bytes[dnstapFieldIdentity]) != "test" {
+		t.Errorf("identity is %q", dnstap.bytes[dnstapFieldIdentity])
+	}
+	if dnstap.numbers[dnstapFieldType] != dnstapTypeMessage {
+		t.Errorf("type is %d", dnstap.numbers[dnstapFieldType])
+	}
+
+	message := decodeProto(t, dnstap.bytes[dnstapFieldMessage])
+	if DnstapMessageType(message.numbers[messageFieldType]) != expected.Type {
+		t.Errorf("message type is %d, expected %d", message.numbers[messageFieldType], expected.Type)
+	}
+
+	ip, port, _ := splitAddr(expected.QueryAddr)
+	if !net.IP(message.bytes[messageFieldQueryAddress]).Equal(ip) || message.numbers[messageFieldQueryPort] != uint64(port) {
+		t.Errorf("query address is %v port %d", net.IP(message.bytes[messageFieldQueryAddress]), message.numbers[messageFieldQueryPort])
+	}
+
+	switch {
+	case expected.Query != nil:
+		if string(message.bytes[messageFieldQueryMessage]) != string(expected.Query) {
+			t.Errorf("query message is %q", message.bytes[messageFieldQueryMessage])
+		}
+		if message.numbers[messageFieldQueryTimeSec] != uint64(expected.QueryTime.Unix()) ||
+			message.numbers[messageFieldQueryTimeNsec] != uint64(expected.QueryTime.Nanosecond()) {
+			t.Errorf("query time is %d.%d", message.numbers[messageFieldQueryTimeSec], message.numbers[messageFieldQueryTimeNsec])
+		}
+	default:
+		if string(message.bytes[messageFieldResponseMessage]) != string(expected.Response) {
+			t.Errorf("response message is %q", message.bytes[messageFieldResponseMessage])
+		}
+		if message.numbers[messageFieldResponseTimeSec] != uint64(expected.ResponseTime.Unix()) {
+			t.Errorf("response time is %d", message.numbers[messageFieldResponseTimeSec])
+		}
+	}
+}
+
+func TestDnstapWriterSendsToSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnstap.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	expected := testDnstapMessages()
+	frames := make([][]byte, 0)
+	received := make(chan error, 1)
+
+	// The reader side of a bidirectional Frame Streams connection
+	go func() {
+		received <- func() error {
+			conn, err := listener.Accept()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			controlType, contentTypes, err := readControlFrame(conn)
+			if err != nil {
+				return err
+			}
+			if controlType != FSTRM_CONTROL_READY || len(contentTypes) != 1 || contentTypes[0] != DNSTAP_CONTENT_TYPE {
+				return fmt.Errorf("expected READY for %s, got %d %v", DNSTAP_CONTENT_TYPE, controlType, contentTypes)
+			}
+
+			err = writeControlFrame(conn, FSTRM_CONTROL_ACCEPT, DNSTAP_CONTENT_TYPE)
+			if err != nil {
+				return err
+			}
+
+			reader := bufio.NewReader(conn)
+			controlType, _, err = readControlFrame(reader)
+			if err != nil {
+				return err
+			}
+			if controlType != FSTRM_CONTROL_START {
+				return fmt.Errorf("expected START, got %d", controlType)
+			}
+
+			for {
+				frame, controlType, err := readFrame(reader)
+				if err != nil {
+					return err
+				}
+				if frame == nil {
+					if controlType != FSTRM_CONTROL_STOP {
+						return fmt.Errorf("expected STOP, got %d", controlType)
+					}
+					break
+				}
+				frames = append(frames, frame)
+			}
+
+			return writeControlFrame(conn, FSTRM_CONTROL_FINISH)
+		}()
+	}()
+
+	writer, err := InitDnstapWriter(DnstapOptions{SocketPath: path, Identity: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, message := range expected {
+		writer.Write(message)
+	}
+	writer.Close()
+
+	err = <-received
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(frames) != len(expected) {
+		t.Fatalf("received %d frames, expected %d", len(frames), len(expected))
+	}
+	for i, frame := range frames {
+		checkDnstapFrame(t, frame, expected[i])
+	}
+
+	if writer.Dropped() != 0 {
+		t.Errorf("%d messages dropped", writer.Dropped())
+	}
+}
+
+func TestDnstapWriterWritesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dnstap.fstrm")
+
+	writer, err := InitDnstapWriter(DnstapOptions{FilePath: path, Identity: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := testDnstapMessages()
+	for _, message := range expected {
+		writer.Write(message)
+	}
+	writer.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	controlType, contentTypes, err := readControlFrame(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if controlType != FSTRM_CONTROL_START || len(contentTypes) != 1 || contentTypes[0] != DNSTAP_CONTENT_TYPE {
+		t.Fatalf("expected START for %s, got %d %v", DNSTAP_CONTENT_TYPE, controlType, contentTypes)
+	}
+
+	for _, message := range expected {
+		frame, controlType, err := readFrame(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame == nil {
+			t.Fatalf("expected a data frame, got control frame %d", controlType)
+		}
+		checkDnstapFrame(t, frame, message)
+	}
+
+	_, controlType, err = readFrame(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if controlType != FSTRM_CONTROL_STOP {
+		t.Fatalf("expected STOP, got %d", controlType)
+	}
+}
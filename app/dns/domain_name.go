@@ -3,6 +3,7 @@ package dns
 import (
 	"encoding/binary"
 	"fmt"
+	"strings"
 )
 
 type Label string
@@ -13,29 +14,184 @@ type DomainName struct {
 
 const MAX_LABEL_LENGTH = 63
 
+// The maximum length of a domain name in wire format, including the length
+// octets and the terminating null byte (RFC 1035 section 2.3.4).
+const MAX_NAME_LENGTH = 255
+
 func (d *DomainName) Serialize() ([]byte, error) {
 	// Labels are encoded as <length><content>, where <length> is a single byte
 	// that specifies the length of the label, and <content> is the actual
 	// content of the label. The sequence of labels is terminated by a null
 	// byte (\x00).
-	buf := make([]byte, 0)
+	w := newMessageWriter(false)
+
+	err := w.writeDomainName(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.bytes(), nil
+}
+
+// Reports whether both names are the same. Domain names are compared
+// case-insensitively (RFC 4343).
+func (d *DomainName) Equal(other *DomainName) bool {
+	if len(d.Labels) != len(other.Labels) {
+		return false
+	}
+
+	for i, label := range d.Labels {
+		if !strings.EqualFold(string(label), string(other.Labels[i])) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The length of the uncompressed wire format of the name.
+func (d *DomainName) wireLength() int {
+	length := 1
+	for _, label := range d.Labels {
+		length += 1 + len(label)
+	}
 
+	return length
+}
+
+// Reports whether the name is equal to or below parent in the domain name
+// space, e.g. "www.example.com" is a subdomain of "example.com" and of the
+// root.
+func (d *DomainName) IsSubdomainOf(parent *DomainName) bool {
+	offset := len(d.Labels) - len(parent.Labels)
+	if offset < 0 {
+		return false
+	}
+
+	suffix := DomainName{Labels: d.Labels[offset:]}
+	return suffix.Equal(parent)
+}
+
+// Returns the name with its first label removed. The parent of the root is
+// the root.
+func (d *DomainName) Parent() DomainName {
+	if len(d.Labels) == 0 {
+		return *d
+	}
+
+	return DomainName{Labels: d.Labels[1:]}
+}
+
+// Returns the name in its absolute textual form, e.g. "www.example.com.".
+// Dots and other special characters within labels are escaped (RFC 1035
+// section 5.1).
+func (d *DomainName) String() string {
+	if len(d.Labels) == 0 {
+		return "."
+	}
+
+	var builder strings.Builder
 	for _, label := range d.Labels {
-		labelLength := len(label)
-		// The high order two bits of every length octet must be zero, and the
-		// remaining six bits of the length field limit the label to 63 octets or
-		// less.
-		if labelLength > MAX_LABEL_LENGTH {
-			return nil, fmt.Errorf("label length %d exceeds maximum of 63", labelLength)
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			switch {
+			case c == '.' || c == '\\' || c == '"' || c == ';' || c == '(' || c == ')' || c == '@' || c == '$':
+				builder.WriteByte('\\')
+				builder.WriteByte(c)
+			case c <= ' ' || c >= 0x7F:
+				fmt.Fprintf(&builder, "\\%03d", c)
+			default:
+				builder.WriteByte(c)
+			}
+		}
+		builder.WriteByte('.')
+	}
+
+	return builder.String()
+}
+
+// Parses the textual form of a domain name. Names ending with a dot are
+// absolute, others are relative to origin, and "@" stands for origin itself
+// (RFC 1035 section 5.1). origin may be nil if only absolute names are
+// expected.
+func ParseDomainName(text string, origin *DomainName) (*DomainName, error) {
+	if text == "@" {
+		if origin == nil {
+			return nil, fmt.Errorf("no origin for \"@\"")
+		}
+
+		return &DomainName{Labels: append(make([]Label, 0), origin.Labels...)}, nil
+	}
+
+	if text == "." {
+		return &DomainName{Labels: make([]Label, 0)}, nil
+	}
+
+	labels := make([]Label, 0)
+	label := make([]byte, 0, MAX_LABEL_LENGTH)
+	absolute := false
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		switch {
+		case c == '\\':
+			// \DDD is the octet with the decimal value DDD, \X is X itself
+			if i+3 < len(text) && isDigit(text[i+1]) && isDigit(text[i+2]) && isDigit(text[i+3]) {
+				value := int(text[i+1]-'0')*100 + int(text[i+2]-'0')*10 + int(text[i+3]-'0')
+				if value > 0xFF {
+					return nil, fmt.Errorf("invalid escape in domain name %q", text)
+				}
+
+				label = append(label, byte(value))
+				i += 3
+			} else if i+1 < len(text) {
+				label = append(label, text[i+1])
+				i += 1
+			} else {
+				return nil, fmt.Errorf("incomplete escape in domain name %q", text)
+			}
+		case c == '.':
+			if len(label) == 0 {
+				return nil, fmt.Errorf("empty label in domain name %q", text)
+			}
+
+			labels = append(labels, Label(label))
+			label = make([]byte, 0, MAX_LABEL_LENGTH)
+			absolute = i == len(text)-1
+		default:
+			label = append(label, c)
+		}
+	}
+
+	if len(label) > 0 {
+		labels = append(labels, Label(label))
+	}
+
+	for _, l := range labels {
+		if len(l) > MAX_LABEL_LENGTH {
+			return nil, fmt.Errorf("label length %d exceeds maximum of 63 in domain name %q", len(l), text)
+		}
+	}
+
+	if !absolute {
+		if origin == nil {
+			return nil, fmt.Errorf("relative domain name %q without an origin", text)
 		}
 
-		buf = append(buf, byte(labelLength))
-		buf = append(buf, []byte(label)...)
+		labels = append(labels, origin.Labels...)
 	}
 
-	buf = append(buf, 0)
+	name := &DomainName{Labels: labels}
+	if name.wireLength() > MAX_NAME_LENGTH {
+		return nil, fmt.Errorf("domain name %q exceeds maximum length of %d", text, MAX_NAME_LENGTH)
+	}
 
-	return buf, nil
+	return name, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
 }
 
 func deserializeDomainName(buf []byte, offset int) (int, *DomainName, error) {
@@ -49,9 +205,19 @@ func deserializeDomainName(buf []byte, offset int) (int, *DomainName, error) {
 	}, nil
 }
 
+// Reads the labels of a name starting at offset, following compression
+// pointers. Returns the number of bytes the name occupies at offset, which
+// ends after the first pointer. Every pointer must point before the labels
+// leading up to it, so that names can't loop, and the whole name must fit
+// into MAX_NAME_LENGTH octets.
 func deSerializeLabels(buf []byte, offset int) (int, []Label, error) {
-	startOffset := offset
 	labels := make([]Label, 0)
+	bytesRead := 0
+	// Where the labels currently being read start, which pointers must
+	// point before
+	segmentStart := offset
+	// The length of the name on the wire, including the root label
+	nameLength := 1
 
 	for {
 		if isPointer(buf, offset) {
@@ -60,15 +226,17 @@ func deSerializeLabels(buf []byte, offset int) (int, []Label, error) {
 				return 0, nil, err
 			}
 
-			offset += 2
+			if pointerOffset >= segmentStart {
+				return 0, nil, fmt.Errorf("compression pointer at %d to %d doesn't point backwards", offset, pointerOffset)
+			}
 
-			_, pointedLabels, err := deSerializeLabels(buf, pointerOffset)
-			if err != nil {
-				return 0, nil, err
+			if bytesRead == 0 {
+				bytesRead = offset + 2 - segmentStart
 			}
 
-			labels = append(labels, pointedLabels...)
-			break
+			offset = pointerOffset
+			segmentStart = pointerOffset
+			continue
 		}
 
 		// Is a normal label with length prefix
@@ -83,6 +251,11 @@ func deSerializeLabels(buf []byte, offset int) (int, []Label, error) {
 			break
 		}
 
+		nameLength += 1 + labelLength
+		if nameLength > MAX_NAME_LENGTH {
+			return 0, nil, fmt.Errorf("name exceeds maximum length of %d octets", MAX_NAME_LENGTH)
+		}
+
 		label, err := readLabel(buf, offset, labelLength)
 		if err != nil {
 			return 0, nil, err
@@ -92,7 +265,12 @@ func deSerializeLabels(buf []byte, offset int) (int, []Label, error) {
 		labels = append(labels, label)
 	}
 
-	return offset - startOffset, labels, nil
+	// Without a pointer the name ends with the root label
+	if bytesRead == 0 {
+		bytesRead = offset - segmentStart
+	}
+
+	return bytesRead, labels, nil
 }
 
 func isPointer(buf []byte, offset int) bool {
@@ -129,11 +307,14 @@ func readLength(buf []byte, offset int) (int, error) {
 		return 0, fmt.Errorf("not enough bytes to read label length")
 	}
 
-	length := int(buf[offset])
-	if length > MAX_LABEL_LENGTH {
-		return 0, fmt.Errorf("label length %d exceeds maximum of 63", length)
+	// The two high bits select the label type, of which the extended types
+	// 01 and 10 are not supported (RFC 6891 section 5)
+	if buf[offset]&0xC0 != 0 {
+		return 0, fmt.Errorf("unsupported label type 0x%02X", buf[offset]&0xC0)
 	}
 
+	length := int(buf[offset])
+
 	return length, nil
 }
 
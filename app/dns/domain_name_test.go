@@ -0,0 +1,130 @@
+package dns
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func FuzzDeserializeDomainName(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte, offset uint16) {
+		if int(offset) > len(data) {
+			return
+		}
+
+		bytesRead, name, err := deserializeDomainName(data, int(offset))
+		if err != nil {
+			return
+		}
+
+		if bytesRead < 1 || int(offset)+bytesRead > len(data) {
+			t.Fatalf("read %d bytes at offset %d of %d", bytesRead, offset, len(data))
+		}
+
+		if name.wireLength() > MAX_NAME_LENGTH {
+			t.Fatalf("name of %d octets exceeds maximum", name.wireLength())
+		}
+
+		serialized, err := name.Serialize()
+		if err != nil {
+			t.Fatalf("failed to serialize parsed name: %v", err)
+		}
+
+		bytesRead, reparsed, err := deserializeDomainName(serialized, 0)
+		if err != nil {
+			t.Fatalf("failed to parse serialized name: %v", err)
+		}
+
+		if bytesRead != len(serialized) || !name.Equal(reparsed) {
+			t.Fatalf("name changed in round trip: %s became %s", name.String(), reparsed.String())
+		}
+	})
+}
+
+func TestDeserializeDomainNameFollowsPointers(t *testing.T) {
+	// "example.com" at 0, "www" pointing to it at 13
+	data := []byte{7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 3, 'w', 'w', 'w', 0xC0, 0}
+
+	bytesRead, name, err := deserializeDomainName(data, 13)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytesRead != 6 || name.String() != "www.example.com." {
+		t.Fatalf("got %s reading %d bytes", name.String(), bytesRead)
+	}
+}
+
+func TestDeserializeDomainNameRejectsLongNames(t *testing.T) {
+	// Four labels of 63 octets make a name of 257 octets
+	label := append([]byte{63}, bytes.Repeat([]byte{'a'}, 63)...)
+	data := append(bytes.Repeat(label, 4), 0)
+
+	_, _, err := deserializeDomainName(data, 0)
+	if err == nil || !strings.Contains(err.Error(), "maximum length") {
+		t.Fatalf("expected the name to be rejected, got %v", err)
+	}
+}
+
+func TestDeserializeDomainNameRejectsBadPointers(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		offset int
+		err    string
+	}{
+		{"pointer to itself", []byte{0xC0, 0}, 0, "doesn't point backwards"},
+		{"forward pointer", []byte{0xC0, 2, 0}, 0, "doesn't point backwards"},
+		// The second pointer points back before the first one, but into the
+		// labels leading up to it, which would loop
+		{"pointer into its own labels", []byte{1, 'a', 0xC0, 0}, 0, "doesn't point backwards"},
+		{"pointer after following a pointer", []byte{1, 'a', 0xC0, 4, 1, 'b', 0xC0, 0}, 4, "doesn't point backwards"},
+		{"truncated pointer", []byte{1, 'a', 0xC0}, 0, ""},
+		{"extended label type", []byte{0x41, 'a', 0}, 0, "unsupported label type 0x40"},
+		{"reserved label type", []byte{0x80, 'a', 0}, 0, "unsupported label type 0x80"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := deserializeDomainName(test.data, test.offset)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("error is %v, expected %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestDeserializeDomainNameCountsOnlyTheFirstSegment(t *testing.T) {
+	// "com" at 0, "example" pointing to it at 5, "www" pointing to that at 15
+	data := []byte{3, 'c', 'o', 'm', 0, 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0xC0, 0, 3, 'w', 'w', 'w', 0xC0, 5}
+
+	bytesRead, name, err := deserializeDomainName(data, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The name occupies its label and the first pointer at its offset
+	if bytesRead != 6 || name.String() != "www.example.com." {
+		t.Fatalf("got %s reading %d bytes", name.String(), bytesRead)
+	}
+}
+
+func TestDeserializeDomainNameRejectsLongNamesAcrossPointers(t *testing.T) {
+	// Two labels of 63 octets, and two more pointing to them, make a name of
+	// 257 octets, while neither part is too long on its own
+	label := append([]byte{63}, bytes.Repeat([]byte{'a'}, 63)...)
+	data := append(bytes.Repeat(label, 2), 0)
+	second := len(data)
+	data = append(data, bytes.Repeat(label, 2)...)
+	data = append(data, 0xC0, 0)
+
+	_, _, err := deserializeDomainName(data, 0)
+	if err != nil {
+		t.Fatalf("first part was rejected: %v", err)
+	}
+
+	_, _, err = deserializeDomainName(data, second)
+	if err == nil || !strings.Contains(err.Error(), "maximum length") {
+		t.Fatalf("expected the name to be rejected, got %v", err)
+	}
+}
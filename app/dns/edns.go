@@ -0,0 +1,205 @@
+package dns
+
+import (
+	"fmt"
+)
+
+// The EDNS version implemented by this package (RFC 6891).
+const EDNS_VERSION = 0
+
+// The payload size a UDP message is limited to when the requester does not
+// advertise a larger one via EDNS (RFC 1035 section 2.3.4).
+const MIN_UDP_PAYLOAD_SIZE = 512
+
+// Extended response codes, which don't fit into the 4 bits of the header.
+// The upper 8 bits are carried in the OPT record (RFC 6891 section 6.1.3).
+const (
+	// Bad OPT Version - The responder does not implement the requested
+	// version of EDNS.
+	RCodeBadVersion ResponseCode = 16
+)
+
+// The EDNS(0) parameters of a message, which are carried in the OPT
+// pseudo-record of the additional section (RFC 6891 section 6.1.2).
+//
+//	+------------+--------------+------------------------------+
+//	| Field Name | Field Type   | Description                  |
+//	+------------+--------------+------------------------------+
+//	| NAME       | domain name  | MUST be 0 (root domain)      |
+//	| TYPE       | u_int16_t    | OPT (41)                     |
+//	| CLASS      | u_int16_t    | requestor's UDP payload size |
+//	| TTL        | u_int32_t    | extended RCODE and flags     |
+//	| RDLEN      | u_int16_t    | length of all RDATA          |
+//	| RDATA      | octet stream | {attribute,value} pairs      |
+//	+------------+--------------+------------------------------+
+type EDNS struct {
+	// The largest UDP payload the sender is able to reassemble.
+	UDPSize uint16
+	// The upper 8 bits of the 12 bit extended RCODE.
+	ExtendedRCode uint8
+	// The EDNS version of the sender.
+	Version uint8
+	// DNSSEC OK. The sender is able to accept DNSSEC security RRs.
+	DO      bool
+	Options []EDNSOption
+}
+
+// A single {attribute,value} pair of the OPT RDATA.
+//
+//	            +0 (MSB)                            +1 (LSB)
+//	+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+//	|                          OPTION-CODE                          |
+//	+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+//	|                         OPTION-LENGTH                         |
+//	+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+//	/                          OPTION-DATA                          /
+//	+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+---+
+type EDNSOption struct {
+	Code uint16
+	Data []byte
+}
+
+// The RDATA of an OPT pseudo-record.
+type OPTData struct {
+	Options []EDNSOption
+}
+
+func (d *OPTData) serializeTo(w *messageWriter) error {
+	for _, option := range d.Options {
+		if len(option.Data) > 0xFFFF {
+			return fmt.Errorf("EDNS option length %d exceeds maximum of 65535", len(option.Data))
+		}
+
+		w.writeUint16(option.Code)
+		w.writeUint16(uint16(len(option.Data)))
+		w.writeBytes(option.Data)
+	}
+
+	return nil
+}
+
+func deserializeOPTData(buf []byte, offset int) (int, *OPTData, error) {
+	startOffset := offset
+	options := make([]EDNSOption, 0)
+
+	for offset < len(buf) {
+		if len(buf) < offset+4 {
+			return 0, nil, fmt.Errorf("not enough bytes to read EDNS option header")
+		}
+
+		code, err := uint16FromBytes(buf[offset : offset+2])
+		if err != nil {
+			return 0, nil, err
+		}
+
+		length, err := uint16FromBytes(buf[offset+2 : offset+4])
+		if err != nil {
+			return 0, nil, err
+		}
+		offset += 4
+
+		if len(buf) < offset+int(length) {
+			return 0, nil, fmt.Errorf("not enough bytes to read EDNS option data")
+		}
+
+		data := make([]byte, length)
+		copy(data, buf[offset:])
+		offset += int(length)
+
+		options = append(options, EDNSOption{Code: code, Data: data})
+	}
+
+	return offset - startOffset, &OPTData{Options: options}, nil
+}
+
+// Returns the EDNS parameters of the message, or nil if it has no OPT record.
+func (m *Message) EDNS() *EDNS {
+	for _, record := range m.Additional {
+		if record.Type != TYPE_OPT {
+			continue
+		}
+
+		edns := &EDNS{
+			UDPSize:       uint16(record.Class),
+			ExtendedRCode: uint8(record.TTL >> 24),
+			Version:       uint8(record.TTL >> 16),
+			DO:            record.TTL&(1<<15) != 0,
+		}
+
+		if opt, ok := record.RData.(*OPTData); ok {
+			edns.Options = opt.Options
+		}
+
+		return edns
+	}
+
+	return nil
+}
+
+// Returns the RCODE of the message, including its upper bits if they are
+// carried in an OPT record.
+func (m *Message) ResponseCode() ResponseCode {
+	rcode := m.Header.Flags.RCODE
+	if edns := m.EDNS(); edns != nil {
+		rcode |= ResponseCode(edns.ExtendedRCode) << 4
+	}
+
+	return rcode
+}
+
+// Replaces the OPT record of the message with one carrying the given EDNS
+// parameters, or removes it if edns is nil.
+func (m *Message) SetEDNS(edns *EDNS) {
+	additional := make([]ResourceRecord, 0, len(m.Additional)+1)
+	for _, record := range m.Additional {
+		if record.Type != TYPE_OPT {
+			additional = append(additional, record)
+		}
+	}
+
+	if edns != nil {
+		additional = append(additional, edns.ResourceRecord())
+	}
+
+	m.Additional = additional
+	m.Header.ARCOUNT = uint16(len(additional))
+}
+
+// Returns the OPT pseudo-record carrying the EDNS parameters.
+func (e *EDNS) ResourceRecord() ResourceRecord {
+	ttl := uint32(e.ExtendedRCode)<<24 | uint32(e.Version)<<16
+	if e.DO {
+		ttl |= 1 << 15
+	}
+
+	options := e.Options
+	if options == nil {
+		options = make([]EDNSOption, 0)
+	}
+
+	return ResourceRecord{
+		Name:  DomainName{Labels: make([]Label, 0)},
+		Type:  TYPE_OPT,
+		Class: ResourceRecordClass(e.UDPSize),
+		TTL:   ttl,
+		RData: &OPTData{Options: options},
+	}
+}
+
+// Returns the largest UDP response the sender of the request is able to
+// receive, but at most serverSize, the payload size the server advertises.
+// Larger responses would rely on IP fragmentation, or not be sendable at
+// all. Advertised sizes below 512 are treated as 512 (RFC 6891 section
+// 6.2.5).
+func MaxUDPPayloadSize(request *Message, serverSize int) int {
+	edns := request.EDNS()
+	if edns == nil || edns.UDPSize < MIN_UDP_PAYLOAD_SIZE {
+		return MIN_UDP_PAYLOAD_SIZE
+	}
+
+	if int(edns.UDPSize) > serverSize {
+		return serverSize
+	}
+
+	return int(edns.UDPSize)
+}
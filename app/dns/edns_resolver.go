@@ -0,0 +1,63 @@
+package dns
+
+import "context"
+
+// Wraps another resolver to answer the EDNS part of requests. Requests of an
+// unsupported EDNS version are rejected with BADVERS, otherwise the OPT
+// record of the request is echoed into the response with our own payload
+// size (RFC 6891 section 6.1.1).
+type EDNSResolver struct {
+	next ContextResolver
+	// The largest UDP payload advertised to requesters.
+	udpSize uint16
+}
+
+func InitEDNSResolver(next ContextResolver, udpSize uint16) (*EDNSResolver, error) {
+	return &EDNSResolver{
+		next:    next,
+		udpSize: udpSize,
+	}, nil
+}
+
+func (r *EDNSResolver) Resolve(request *Message) *Message {
+	return resolveWithoutContext(r, request)
+}
+
+func (r *EDNSResolver) ResolveContext(ctx context.Context, info *RequestInfo, request *Message) (*Message, error) {
+	requestEDNS := request.EDNS()
+	if requestEDNS == nil {
+		return r.next.ResolveContext(ctx, info, request)
+	}
+
+	if requestEDNS.Version > EDNS_VERSION {
+		response := MakeErrorResponse(request, RCodeBadVersion&0x0F)
+		response.SetEDNS(&EDNS{
+			UDPSize:       r.udpSize,
+			ExtendedRCode: uint8(RCodeBadVersion >> 4),
+			Version:       EDNS_VERSION,
+		})
+
+		return response, nil
+	}
+
+	response, err := r.next.ResolveContext(ctx, info, request)
+	if err != nil {
+		return nil, err
+	}
+
+	// The upper bits of an RCODE set further down the chain live in the OPT
+	// record being replaced
+	var extendedRCode uint8
+	if responseEDNS := response.EDNS(); responseEDNS != nil {
+		extendedRCode = responseEDNS.ExtendedRCode
+	}
+
+	response.SetEDNS(&EDNS{
+		UDPSize:       r.udpSize,
+		ExtendedRCode: extendedRCode,
+		Version:       EDNS_VERSION,
+		DO:            requestEDNS.DO,
+	})
+
+	return response, nil
+}
@@ -0,0 +1,39 @@
+package dns
+
+import (
+	"testing"
+)
+
+// Answers every request with an extended RCODE, as an upstream might.
+type extendedRCodeResolver struct {
+	rcode ResponseCode
+}
+
+func (r *extendedRCodeResolver) Resolve(request *Message) *Message {
+	response := MakeErrorResponse(request, r.rcode&0x0F)
+	response.SetEDNS(&EDNS{UDPSize: 1232, ExtendedRCode: uint8(r.rcode >> 4)})
+	return response
+}
+
+func TestEDNSResolverKeepsExtendedRCode(t *testing.T) {
+	// BADCOOKIE, which doesn't fit into the four bits of the header
+	const rcodeBadCookie ResponseCode = 23
+
+	resolver, err := InitEDNSResolver(AdaptResolver(&extendedRCodeResolver{rcode: rcodeBadCookie}), 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := &Message{Header: Header{ID: 1}}
+	request.SetEDNS(&EDNS{UDPSize: 512, DO: true})
+
+	response := resolver.Resolve(request)
+	if rcode := response.ResponseCode(); rcode != rcodeBadCookie {
+		t.Errorf("RCODE is %v, expected %v", rcode, rcodeBadCookie)
+	}
+
+	edns := response.EDNS()
+	if edns == nil || edns.UDPSize != 4096 || !edns.DO {
+		t.Errorf("OPT record is %+v, expected our payload size and the request's DO bit", edns)
+	}
+}
@@ -0,0 +1,172 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Sends the request to the server and waits for its response. The request is
+// sent over UDP from a socket of its own, so only replies from the server
+// reach it, and replies whose ID or question don't match the request are
+// ignored. If the response is truncated the request is repeated over TCP.
+// The exchange is given up after the timeout, or once ctx is done.
+func Exchange(ctx context.Context, request *Message, server string, timeout time.Duration) (*Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	response, err := exchangeUDP(ctx, request, server)
+	if err == nil && response.Header.Flags.TC {
+		response, err = exchangeTCP(ctx, request, server)
+	}
+
+	return response, exchangeError(ctx, err)
+}
+
+// Sends the request to the server over UDP only, and waits for its response,
+// which is returned even if it is truncated. The exchange is given up after
+// the timeout, or once ctx is done.
+func ExchangeUDP(ctx context.Context, request *Message, server string, timeout time.Duration) (*Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	response, err := exchangeUDP(ctx, request, server)
+	return response, exchangeError(ctx, err)
+}
+
+// Sends the request to the server over TCP only (RFC 7766), and waits for its
+// response. The exchange is given up after the timeout, or once ctx is done.
+func ExchangeTCP(ctx context.Context, request *Message, server string, timeout time.Duration) (*Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	response, err := exchangeTCP(ctx, request, server)
+	return response, exchangeError(ctx, err)
+}
+
+func exchangeError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		// The connection was closed because ctx is done, which is the more
+		// useful error
+		return ctx.Err()
+	}
+
+	return err
+}
+
+func exchangeUDP(ctx context.Context, request *Message, server string) (*Message, error) {
+	requestSerialized, err := request.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	stop := closeWhenDone(ctx, conn)
+	defer stop()
+
+	_, err = conn.Write(requestSerialized)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, MAX_TCP_MESSAGE_SIZE)
+	for {
+		size, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := DeserializeMessage(buf[:size])
+		if err != nil || !isResponseTo(request, response) {
+			// Keep waiting, the real response may still arrive
+			continue
+		}
+
+		return response, nil
+	}
+}
+
+func exchangeTCP(ctx context.Context, request *Message, server string) (*Message, error) {
+	requestSerialized, err := request.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	stop := closeWhenDone(ctx, conn)
+	defer stop()
+
+	err = WriteTCPMessage(conn, requestSerialized)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ReadTCPMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := DeserializeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isResponseTo(request, response) {
+		return nil, fmt.Errorf("response from %s doesn't match the request", server)
+	}
+
+	return response, nil
+}
+
+// Whether the message is a response to the request: it carries the same ID
+// and, if it has any, the same questions.
+func isResponseTo(request *Message, response *Message) bool {
+	if !response.Header.Flags.QR || response.Header.ID != request.Header.ID {
+		return false
+	}
+
+	// Some errors, e.g. FORMERR, are answered without the question
+	if len(response.Questions) == 0 {
+		return response.Header.Flags.RCODE != RCodeNoError
+	}
+
+	if len(response.Questions) != len(request.Questions) {
+		return false
+	}
+
+	for i := range request.Questions {
+		if !request.Questions[i].Equal(&response.Questions[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Closes the connection once ctx is done, which interrupts any read or write
+// in progress. The returned function stops waiting for ctx.
+func closeWhenDone(ctx context.Context, conn net.Conn) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	return func() { close(stop) }
+}
@@ -1,53 +1,128 @@
 package dns
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 )
 
+// The UDP payload size advertised to upstream servers, and therefore the
+// largest response read from them.
+const FORWARDER_UDP_SIZE = 4096
+
+// How long to wait for an upstream to answer a query before trying the next.
+const FORWARDER_TIMEOUT = 5 * time.Second
+
+// Forwards questions to one of several upstream resolvers, chosen by an
+// UpstreamPolicy. When an upstream fails to answer the next one is tried, and
+// upstreams which keep failing are marked down for a while.
+//
+// Queries to the upstreams are sent from a single socket, so replies are
+// matched to the queries waiting for them by their ID, question and source,
+// which makes the resolver safe for concurrent use. Replies from anywhere but
+// the queried upstream, and replies nobody is waiting for, are discarded.
 type ForwardingResolver struct {
-	udpAddr *net.UDPAddr
-	udpConn *net.UDPConn
+	upstreams *upstreamSelector
+	udpConn   *net.UDPConn
+
+	mutex sync.Mutex
+	// Queries waiting for a reply, keyed by the ID they were sent with.
+	pending map[uint16]*pendingQuery
+
+	// Receives the queries sent to upstreams and their replies, nil if they
+	// aren't logged.
+	dnstap *DnstapWriter
 }
 
-func InitForwardingResolver(serverAddr string) (*ForwardingResolver, error) {
-	// Resolve the UDP address
-	udpAddr, err := net.ResolveUDPAddr("udp", serverAddr)
-	if err != nil {
-		fmt.Println("Error resolving UDP address:", err)
-		return nil, err
+type pendingQuery struct {
+	question Question
+	source   *net.UDPAddr
+	// Receives the matching reply, buffered so that the reader never blocks.
+	response chan *Message
+}
+
+func InitForwardingResolver(serverAddrs []string, policy UpstreamPolicy) (*ForwardingResolver, error) {
+	if len(serverAddrs) == 0 {
+		return nil, fmt.Errorf("no upstream resolvers given")
 	}
 
-	// Create a UDP connection
-	udpConn, err := net.DialUDP("udp", nil, udpAddr)
+	upstreams := make([]*upstream, 0, len(serverAddrs))
+	for _, serverAddr := range serverAddrs {
+		// Resolve the UDP address
+		udpAddr, err := net.ResolveUDPAddr("udp", serverAddr)
+		if err != nil {
+			fmt.Println("Error resolving UDP address:", err)
+			return nil, err
+		}
+
+		upstreams = append(upstreams, &upstream{addr: udpAddr})
+	}
+
+	// Create an unconnected UDP socket, so that the source of every reply can
+	// be checked against the upstream it is expected from
+	udpConn, err := net.ListenUDP("udp", nil)
 	if err != nil {
-		fmt.Println("Error dialing UDP:", err)
+		fmt.Println("Error creating UDP socket:", err)
 		return nil, err
 	}
 
-	return &ForwardingResolver{
-		udpAddr: udpAddr,
+	r := &ForwardingResolver{
+		upstreams: &upstreamSelector{
+			upstreams: upstreams,
+			policy:    policy,
+			now:       time.Now,
+		},
 		udpConn: udpConn,
-	}, nil
+		pending: make(map[uint16]*pendingQuery),
+	}
+
+	go r.readResponses()
+
+	return r, nil
 }
 
 func (r *ForwardingResolver) Resolve(msg *Message) *Message {
+	return resolveWithoutContext(r, msg)
+}
+
+func (r *ForwardingResolver) ResolveContext(ctx context.Context, info *RequestInfo, msg *Message) (*Message, error) {
 	answers := make([]ResourceRecord, 0)
+	authority := make([]ResourceRecord, 0)
+	additional := make([]ResourceRecord, 0)
 	isValidRequest := msg.Header.Flags.OPCODE == 0
 	returnCode := RCodeNoError
 	if !isValidRequest {
 		returnCode = RCodeNotImplemented
 	}
+	truncated := false
 
 	if isValidRequest {
 		for _, question := range msg.Questions {
-			answer, err := r.resolveQuestion(msg, &question)
+			answer, err := r.resolveQuestion(ctx, info, &question)
 			if err != nil {
-				return makeErrorResponse(msg, RCodeServerFailure)
+				return nil, err
 			}
 
+			// The OPT record of the upstream is hop-by-hop and not relayed
+			answer.SetEDNS(nil)
+
 			answers = append(answers, answer.Answers...)
+			authority = append(authority, answer.Authority...)
+			additional = append(additional, answer.Additional...)
+
+			// Relay negative answers (e.g. NXDOMAIN) from the upstream
+			if returnCode == RCodeNoError {
+				returnCode = answer.Header.RCODE
+			}
+
+			// Even over TCP the upstream may not have sent all of it, which
+			// keeps the answer out of the cache
+			truncated = truncated || answer.Header.Flags.TC
 		}
 	}
 
@@ -58,7 +133,7 @@ func (r *ForwardingResolver) Resolve(msg *Message) *Message {
 				QR:     true,
 				OPCODE: msg.Header.OPCODE,
 				AA:     false,
-				TC:     false,
+				TC:     truncated,
 				RD:     msg.Header.RD,
 				RA:     false,
 				Z:      0,
@@ -66,16 +141,57 @@ func (r *ForwardingResolver) Resolve(msg *Message) *Message {
 			},
 			QDCOUNT: uint16(len(msg.Questions)),
 			ANCOUNT: uint16(len(answers)),
-			NSCOUNT: 0,
-			ARCOUNT: 0,
+			NSCOUNT: uint16(len(authority)),
+			ARCOUNT: uint16(len(additional)),
 		},
-		Questions: msg.Questions,
-		Answers:   answers,
+		Questions:  msg.Questions,
+		Answers:    answers,
+		Authority:  authority,
+		Additional: additional,
+	}, nil
+}
+
+// Asks the upstreams in turn until one of them answers the question, or
+// until ctx is done. The upstream which answered is recorded in info.
+func (r *ForwardingResolver) resolveQuestion(ctx context.Context, info *RequestInfo, question *Question) (*Message, error) {
+	var lastErr error
+
+	for _, upstream := range r.upstreams.order() {
+		sentAt := time.Now()
+		response, err := r.queryUpstream(ctx, upstream, question)
+		if err == nil && !isUpstreamFailure(response) {
+			upstream.recordSuccess(time.Since(sentAt))
+			info.Upstream = upstream.addr.String()
+			return response, nil
+		}
+
+		// Giving up on the upstream is not its failure
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+
+		if err == nil {
+			err = fmt.Errorf("%s answered with RCODE %d", upstream.addr, response.Header.RCODE)
+		}
+
+		fmt.Println("Upstream failed:", err)
+		upstream.recordFailure(r.upstreams.now())
+		lastErr = err
 	}
+
+	return nil, lastErr
 }
 
-func (r *ForwardingResolver) resolveQuestion(msg *Message, question *Question) (*Message, error) {
-	requestMsg := questionToMessage(msg.Header.ID, question)
+func (r *ForwardingResolver) queryUpstream(ctx context.Context, upstream *upstream, question *Question) (*Message, error) {
+	query, id, err := r.addPendingQuery(question, upstream.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer r.removePendingQuery(id)
+
+	// Every query gets a random ID of its own, the ID of the client's request
+	// could be guessed and collide with other queries in flight
+	requestMsg := questionToMessage(id, question)
 
 	// Serialize the question
 	requestSerialized, err := requestMsg.Serialize()
@@ -84,42 +200,240 @@ func (r *ForwardingResolver) resolveQuestion(msg *Message, question *Question) (
 	}
 
 	// Send a message to the server
-	_, err = r.udpConn.Write(requestSerialized)
+	_, err = r.udpConn.WriteToUDP(requestSerialized, upstream.addr)
 	if err != nil {
 		return nil, err
 	}
 
-	// Set a deadline for reading the response from the server
-	deadline := time.Now().Add(5 * time.Second)
-	err = r.udpConn.SetReadDeadline(deadline)
-	if err != nil {
-		return nil, err
+	if r.dnstap != nil {
+		r.dnstap.Write(&DnstapMessage{
+			Type:         DnstapForwarderQuery,
+			Transport:    TransportUDP,
+			QueryAddr:    r.udpConn.LocalAddr(),
+			ResponseAddr: upstream.addr,
+			QueryTime:    time.Now(),
+			Query:        requestSerialized,
+		})
 	}
 
-	// Read response from the server
-	buf := make([]byte, 512)
-	size, source, err := r.udpConn.ReadFromUDP(buf)
-	if err != nil {
-		return nil, err
+	timer := time.NewTimer(FORWARDER_TIMEOUT)
+	defer timer.Stop()
+
+	select {
+	case response := <-query.response:
+		if response.Header.Flags.TC {
+			// The sections of a truncated reply may end in the middle of an
+			// RRset, which must neither be relayed nor cached as complete
+			return r.queryUpstreamTCP(ctx, upstream, requestMsg)
+		}
+
+		return response, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("timed out waiting for %s to answer", upstream.addr)
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
+}
 
-	fmt.Printf("Received %d bytes from %s\n", size, source)
+// Repeats a query whose reply was truncated over TCP.
+func (r *ForwardingResolver) queryUpstreamTCP(ctx context.Context, upstream *upstream, request *Message) (*Message, error) {
+	addr := &net.TCPAddr{IP: upstream.addr.IP, Port: upstream.addr.Port, Zone: upstream.addr.Zone}
+	if r.dnstap != nil {
+		if serialized, err := request.Serialize(); err == nil {
+			r.dnstap.Write(&DnstapMessage{
+				Type:         DnstapForwarderQuery,
+				Transport:    TransportTCP,
+				ResponseAddr: addr,
+				QueryTime:    time.Now(),
+				Query:        serialized,
+			})
+		}
+	}
 
-	// Deserialize the response
-	response, err := DeserializeMessage(buf[:size])
+	response, err := ExchangeTCP(ctx, request, addr.String(), FORWARDER_TIMEOUT)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to repeat truncated query to %s over TCP: %w", upstream.addr, err)
+	}
+
+	if r.dnstap != nil {
+		if serialized, err := response.Serialize(); err == nil {
+			r.dnstap.Write(&DnstapMessage{
+				Type:         DnstapForwarderResponse,
+				Transport:    TransportTCP,
+				ResponseAddr: addr,
+				ResponseTime: time.Now(),
+				Response:     serialized,
+			})
+		}
 	}
 
 	return response, nil
 }
 
+// Whether the reply means the upstream couldn't answer, so another upstream
+// should be asked instead.
+func isUpstreamFailure(response *Message) bool {
+	code := response.Header.RCODE
+	return code == RCodeServerFailure || code == RCodeNotImplemented || code == RCodeRefused
+}
+
+// Registers a query for the question under a random, currently unused ID.
+func (r *ForwardingResolver) addPendingQuery(question *Question, source *net.UDPAddr) (*pendingQuery, uint16, error) {
+	query := &pendingQuery{
+		question: *question,
+		source:   source,
+		response: make(chan *Message, 1),
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.pending) > 0xFFFF {
+		return nil, 0, fmt.Errorf("too many queries in flight")
+	}
+
+	for {
+		id, err := randomID()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if _, ok := r.pending[id]; !ok {
+			r.pending[id] = query
+			return query, id, nil
+		}
+	}
+}
+
+func (r *ForwardingResolver) removePendingQuery(id uint16) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.pending, id)
+}
+
+// Reads replies from the socket and hands each one to the query waiting for
+// it, until the socket is closed.
+func (r *ForwardingResolver) readResponses() {
+	buf := make([]byte, FORWARDER_UDP_SIZE)
+
+	for {
+		size, source, err := r.udpConn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				fmt.Println("Error receiving data from upstream:", err)
+			}
+			return
+		}
+
+		// Deserialize the response
+		response, err := DeserializeMessage(buf[:size])
+		if err != nil {
+			fmt.Println("Discarding malformed reply from", source, err)
+			continue
+		}
+
+		if r.deliverResponse(source, response) && r.dnstap != nil {
+			r.dnstap.Write(&DnstapMessage{
+				Type:         DnstapForwarderResponse,
+				Transport:    TransportUDP,
+				QueryAddr:    r.udpConn.LocalAddr(),
+				ResponseAddr: source,
+				ResponseTime: time.Now(),
+				Response:     buf[:size],
+			})
+		}
+	}
+}
+
+// Hands the reply to the query waiting for it. Returns whether there was
+// one.
+func (r *ForwardingResolver) deliverResponse(source *net.UDPAddr, response *Message) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	query, ok := r.pending[response.Header.ID]
+	if !ok {
+		fmt.Println("Discarding unexpected or late reply from", source)
+		return false
+	}
+
+	if !source.IP.Equal(query.source.IP) || source.Port != query.source.Port {
+		fmt.Println("Discarding reply from unexpected source", source)
+		return false
+	}
+
+	if !response.Header.QR || len(response.Questions) != 1 || !response.Questions[0].Equal(&query.question) {
+		fmt.Println("Discarding reply with mismatched question from", source)
+		return false
+	}
+
+	// Once answered, later replies with the same ID are unexpected
+	delete(r.pending, response.Header.ID)
+	query.response <- response
+	return true
+}
+
+// Returns the stats of every upstream, in the configured order.
+func (r *ForwardingResolver) UpstreamStats() []UpstreamStats {
+	now := r.upstreams.now()
+	stats := make([]UpstreamStats, 0, len(r.upstreams.upstreams))
+	for _, upstream := range r.upstreams.upstreams {
+		stats = append(stats, upstream.stats(now))
+	}
+
+	return stats
+}
+
+// Shares what the previous resolver learned about the upstreams both are
+// configured with, including their counts, so that it isn't lost when the
+// configuration is reloaded. Must be called before the resolver is used.
+func (r *ForwardingResolver) TakeOverUpstreams(previous *ForwardingResolver) {
+	known := make(map[string]*upstream, len(previous.upstreams.upstreams))
+	for _, upstream := range previous.upstreams.upstreams {
+		known[upstream.addr.String()] = upstream
+	}
+
+	for i, upstream := range r.upstreams.upstreams {
+		if previousUpstream, ok := known[upstream.addr.String()]; ok {
+			r.upstreams.upstreams[i] = previousUpstream
+		}
+	}
+}
+
+// The number of queries sent to upstreams which are still waiting for a
+// reply.
+func (r *ForwardingResolver) QueriesInFlight() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.pending)
+}
+
+// Logs the queries sent to upstreams and their replies via dnstap. Must be
+// called before the resolver is used.
+func (r *ForwardingResolver) SetDnstap(writer *DnstapWriter) {
+	r.dnstap = writer
+}
+
 func (r *ForwardingResolver) Close() {
 	r.udpConn.Close()
 }
 
+// Returns a random message ID, which makes forged replies harder to get
+// accepted (RFC 5452 section 9.2).
+func randomID() (uint16, error) {
+	buf := make([]byte, 2)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint16(buf), nil
+}
+
 func questionToMessage(id uint16, question *Question) *Message {
-	return &Message{
+	message := &Message{
 		Header: Header{
 			ID: id,
 			Flags: Flags{
@@ -142,9 +456,17 @@ func questionToMessage(id uint16, question *Question) *Message {
 		},
 		Answers: make([]ResourceRecord, 0),
 	}
+
+	// Advertise a larger payload size so that big answers don't get truncated
+	message.SetEDNS(&EDNS{
+		UDPSize: FORWARDER_UDP_SIZE,
+		Version: EDNS_VERSION,
+	})
+
+	return message
 }
 
-func makeErrorResponse(msg *Message, code ResponseCode) *Message {
+func MakeErrorResponse(msg *Message, code ResponseCode) *Message {
 	return &Message{
 		Header: Header{
 			ID: msg.Header.ID,
@@ -0,0 +1,281 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func listenTestUpstream(t *testing.T) *net.UDPConn {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+func newTestForwardingResolver(t *testing.T, upstreams ...string) *ForwardingResolver {
+	t.Helper()
+
+	resolver, err := InitForwardingResolver(upstreams, PolicySequential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(resolver.Close)
+
+	return resolver
+}
+
+func readTestQuery(t *testing.T, conn *net.UDPConn) (*Message, *net.UDPAddr) {
+	buf := make([]byte, MAX_TCP_MESSAGE_SIZE)
+	size, source, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Error(err)
+		return nil, nil
+	}
+
+	query, err := DeserializeMessage(buf[:size])
+	if err != nil {
+		t.Error(err)
+		return nil, nil
+	}
+
+	return query, source
+}
+
+// Builds the reply to the query, with the records given in presentation
+// format.
+func makeTestReply(t *testing.T, query *Message, records ...string) *Message {
+	reply := MakeErrorResponse(query, RCodeNoError)
+	reply.Questions = query.Questions
+	reply.Answers = parseRecords(t, records...)
+	reply.Header.QDCOUNT = uint16(len(reply.Questions))
+	reply.Header.ANCOUNT = uint16(len(reply.Answers))
+
+	return reply
+}
+
+func sendTestReply(t *testing.T, conn *net.UDPConn, reply *Message, destination *net.UDPAddr) {
+	serialized, err := reply.Serialize()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = conn.WriteToUDP(serialized, destination)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func forwardQuestion(resolver *ForwardingResolver, name string) (*Message, error) {
+	qname, err := ParseDomainName(name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &Message{
+		Header:    Header{ID: 1, QDCOUNT: 1, Flags: Flags{RD: true}},
+		Questions: []Question{{Name: *qname, Type: TYPE_A, Class: CLASS_IN}},
+	}
+
+	return resolver.ResolveContext(context.Background(), &RequestInfo{}, request)
+}
+
+func parseQuestions(t *testing.T, names ...string) []Question {
+	questions := make([]Question, 0, len(names))
+	for _, name := range names {
+		qname, err := ParseDomainName(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		questions = append(questions, Question{Name: *qname, Type: TYPE_A, Class: CLASS_IN})
+	}
+
+	return questions
+}
+
+// Whether one of the queries was sent with the ID.
+func pendingID(queries []*Message, id uint16) bool {
+	for _, query := range queries {
+		if query.Header.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestForwardingResolverMatchesConcurrentReplies(t *testing.T) {
+	const queries = 8
+
+	upstream := listenTestUpstream(t)
+	// Sends replies from the wrong address
+	forger := listenTestUpstream(t)
+	resolver := newTestForwardingResolver(t, upstream.LocalAddr().String())
+
+	go func() {
+		received := make([]*Message, 0, queries)
+		var source *net.UDPAddr
+		for len(received) < queries {
+			query, from := readTestQuery(t, upstream)
+			if query == nil {
+				return
+			}
+
+			received = append(received, query)
+			source = from
+		}
+
+		// Answer in reverse order, each preceded by replies which must be
+		// discarded: from the wrong source, for another question and with
+		// an ID nobody is waiting for
+		for i := len(received) - 1; i >= 0; i-- {
+			query := received[i]
+			name := query.Questions[0].Name.String()
+
+			sendTestReply(t, forger, makeTestReply(t, query, name+" 60 IN A 198.51.100.1"), source)
+
+			otherQuestion := makeTestReply(t, query, "other.example.com. 60 IN A 198.51.100.2")
+			otherQuestion.Questions = parseQuestions(t, "other.example.com.")
+			sendTestReply(t, upstream, otherQuestion, source)
+
+			otherID := makeTestReply(t, query, name+" 60 IN A 198.51.100.3")
+			for pendingID(received, otherID.Header.ID) {
+				otherID.Header.ID++
+			}
+			sendTestReply(t, upstream, otherID, source)
+
+			// The name ends in the index of the query
+			var index int
+			fmt.Sscanf(name, "q%d.", &index)
+			sendTestReply(t, upstream, makeTestReply(t, query, fmt.Sprintf("%s 60 IN A 192.0.2.%d", name, index)), source)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < queries; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			name := fmt.Sprintf("q%d.example.com.", i)
+			response, err := forwardQuestion(resolver, name)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				return
+			}
+
+			expected := []string{fmt.Sprintf("%s 60 IN A 192.0.2.%d", name, i)}
+			if answers := recordStrings(response.Answers); !reflect.DeepEqual(answers, expected) {
+				t.Errorf("%s was answered with %q, expected %q", name, answers, expected)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if inFlight := resolver.QueriesInFlight(); inFlight != 0 {
+		t.Errorf("%d queries still in flight", inFlight)
+	}
+}
+
+func TestForwardingResolverTakeOverUpstreamsKeepsStats(t *testing.T) {
+	previous := newTestForwardingResolver(t, "192.0.2.1:53", "192.0.2.2:53")
+	previous.upstreams.upstreams[0].recordSuccess(10 * time.Millisecond)
+	previous.upstreams.upstreams[1].recordFailure(time.Now())
+
+	// One upstream is kept, one replaced and one added
+	resolver := newTestForwardingResolver(t, "192.0.2.3:53", "192.0.2.1:53")
+	resolver.TakeOverUpstreams(previous)
+
+	expected := []UpstreamStats{
+		{Address: "192.0.2.3:53"},
+		{Address: "192.0.2.1:53", RTT: 10 * time.Millisecond, Answers: 1},
+	}
+	if stats := resolver.UpstreamStats(); !reflect.DeepEqual(stats, expected) {
+		t.Errorf("stats are %+v, expected %+v", stats, expected)
+	}
+}
+
+func TestForwardingResolverRepeatsTruncatedQueriesOverTCP(t *testing.T) {
+	records := []string{
+		"www.example.com. 60 IN A 192.0.2.1",
+		"www.example.com. 60 IN A 192.0.2.2",
+		"www.example.com. 60 IN A 192.0.2.3",
+	}
+
+	// The upstream answers over UDP with only part of the RRset and TC set,
+	// and over TCP on the same port with all of it
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: listener.Addr().(*net.TCPAddr).Port})
+	if err != nil {
+		t.Skip("port of the TCP listener is taken over UDP:", err)
+	}
+	defer udpConn.Close()
+
+	go func() {
+		query, source := readTestQuery(t, udpConn)
+		if query == nil {
+			return
+		}
+
+		reply := makeTestReply(t, query, records[0])
+		reply.Header.Flags.TC = true
+		sendTestReply(t, udpConn, reply, source)
+	}()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		data, err := ReadTCPMessage(conn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		query, err := DeserializeMessage(data)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		serialized, err := makeTestReply(t, query, records...).Serialize()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		WriteTCPMessage(conn, serialized)
+	}()
+
+	resolver := newTestForwardingResolver(t, udpConn.LocalAddr().String())
+	response, err := forwardQuestion(resolver, "www.example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Header.Flags.TC {
+		t.Error("TC is set")
+	}
+	if answers := recordStrings(response.Answers); !reflect.DeepEqual(answers, records) {
+		t.Errorf("answers are %q, expected %q", answers, records)
+	}
+}
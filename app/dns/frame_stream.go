@@ -0,0 +1,166 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Control frame types of the Frame Streams protocol, which carries dnstap
+// messages (https://github.com/farsightsec/fstrm).
+const (
+	FSTRM_CONTROL_ACCEPT = 0x01
+	FSTRM_CONTROL_START  = 0x02
+	FSTRM_CONTROL_STOP   = 0x03
+	FSTRM_CONTROL_READY  = 0x04
+	FSTRM_CONTROL_FINISH = 0x05
+)
+
+// The only control field, which names the type of the data frames.
+const FSTRM_CONTROL_FIELD_CONTENT_TYPE = 0x01
+
+// The largest control frame accepted from a reader.
+const FSTRM_MAX_CONTROL_FRAME_SIZE = 512
+
+// A control frame is announced by a data frame length of 0, the escape,
+// followed by the length of the control frame:
+//
+//	+--------------+----------------+--------------+------------------+
+//	| escape (0)   | frame length   | control type | control fields   |
+//	| 4 octets     | 4 octets       | 4 octets     | variable         |
+//	+--------------+----------------+--------------+------------------+
+//
+// Each field is its type, the length of its value and the value, the first
+// two as 4 octets each.
+func writeControlFrame(w io.Writer, controlType uint32, contentTypes ...string) error {
+	// The escape and frame length are filled in once the length is known
+	frame := make([]byte, 8, 64)
+	frame = binary.BigEndian.AppendUint32(frame, controlType)
+
+	for _, contentType := range contentTypes {
+		frame = binary.BigEndian.AppendUint32(frame, FSTRM_CONTROL_FIELD_CONTENT_TYPE)
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(contentType)))
+		frame = append(frame, contentType...)
+	}
+
+	binary.BigEndian.PutUint32(frame[0:4], 0)
+	binary.BigEndian.PutUint32(frame[4:8], uint32(len(frame)-8))
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// Reads a control frame. Returns its type and the content types it names.
+func readControlFrame(r io.Reader) (uint32, []string, error) {
+	header := make([]byte, 8)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if binary.BigEndian.Uint32(header[0:4]) != 0 {
+		return 0, nil, fmt.Errorf("expected a control frame, got a data frame")
+	}
+
+	length := binary.BigEndian.Uint32(header[4:8])
+	if length < 4 || length > FSTRM_MAX_CONTROL_FRAME_SIZE {
+		return 0, nil, fmt.Errorf("invalid control frame length %d", length)
+	}
+
+	frame := make([]byte, length)
+	_, err = io.ReadFull(r, frame)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	controlType := binary.BigEndian.Uint32(frame[0:4])
+	contentTypes := make([]string, 0)
+
+	for offset := 4; offset < len(frame); {
+		if len(frame) < offset+8 {
+			return 0, nil, fmt.Errorf("truncated control field")
+		}
+
+		fieldType := binary.BigEndian.Uint32(frame[offset : offset+4])
+		fieldLength := int(binary.BigEndian.Uint32(frame[offset+4 : offset+8]))
+		offset += 8
+
+		if len(frame)-offset < fieldLength {
+			return 0, nil, fmt.Errorf("truncated control field")
+		}
+
+		if fieldType == FSTRM_CONTROL_FIELD_CONTENT_TYPE {
+			contentTypes = append(contentTypes, string(frame[offset:offset+fieldLength]))
+		}
+		offset += fieldLength
+	}
+
+	return controlType, contentTypes, nil
+}
+
+// Writes a data frame: its length as 4 octets, followed by the data.
+func writeDataFrame(w io.Writer, data []byte) error {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(data)))
+
+	_, err := w.Write(length)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// Agrees on the content type with the reader of a bidirectional stream:
+//
+//	writer: READY, reader: ACCEPT, writer: START
+func startFrameStream(conn io.ReadWriter, contentType string) error {
+	err := writeControlFrame(conn, FSTRM_CONTROL_READY, contentType)
+	if err != nil {
+		return err
+	}
+
+	controlType, contentTypes, err := readControlFrame(conn)
+	if err != nil {
+		return err
+	}
+
+	if controlType != FSTRM_CONTROL_ACCEPT {
+		return fmt.Errorf("expected ACCEPT from the reader, got control frame %d", controlType)
+	}
+
+	accepted := len(contentTypes) == 0
+	for _, accept := range contentTypes {
+		accepted = accepted || accept == contentType
+	}
+	if !accepted {
+		return fmt.Errorf("reader doesn't accept %s", contentType)
+	}
+
+	return writeControlFrame(conn, FSTRM_CONTROL_START, contentType)
+}
+
+// Ends a bidirectional stream: writer: STOP, reader: FINISH. Frames are
+// written to buf, which is flushed to conn.
+func stopFrameStream(conn io.Reader, buf *bufio.Writer) error {
+	err := writeControlFrame(buf, FSTRM_CONTROL_STOP)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	controlType, _, err := readControlFrame(conn)
+	if err != nil {
+		return err
+	}
+
+	if controlType != FSTRM_CONTROL_FINISH {
+		return fmt.Errorf("expected FINISH from the reader, got control frame %d", controlType)
+	}
+
+	return nil
+}
@@ -14,6 +14,21 @@ const (
 	// 3-15            reserved for future use
 )
 
+var opCodeNames = map[OpCode]string{
+	OpcodeQuery:        "QUERY",
+	OpCodeInverseQuery: "IQUERY",
+	OpCodeStatus:       "STATUS",
+}
+
+// Returns the mnemonic of the opcode, as printed by dig.
+func (o OpCode) String() string {
+	if name, ok := opCodeNames[o]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("OPCODE%d", o)
+}
+
 type ResponseCode byte
 
 const (
@@ -40,6 +55,25 @@ const (
 	// 6-15            Reserved for future use.
 )
 
+var responseCodeNames = map[ResponseCode]string{
+	RCodeNoError:        "NOERROR",
+	RCodeFormatError:    "FORMERR",
+	RCodeServerFailure:  "SERVFAIL",
+	RCodeNameError:      "NXDOMAIN",
+	RCodeNotImplemented: "NOTIMP",
+	RCodeRefused:        "REFUSED",
+	RCodeBadVersion:     "BADVERS",
+}
+
+// Returns the mnemonic of the response code, as printed by dig.
+func (c ResponseCode) String() string {
+	if name, ok := responseCodeNames[c]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("RCODE%d", c)
+}
+
 //		                              1  1  1  1  1  1
 //		0  1  2  3  4  5  6  7  8  9  0  1  2  3  4  5
 //	 +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
@@ -98,7 +132,7 @@ func (f *Flags) Serialize() uint16 {
 	if f.QR {
 		flags |= 1 << 15
 	}
-	flags |= uint16(f.OPCODE) << 11
+	flags |= uint16(f.OPCODE&0x0F) << 11
 	if f.AA {
 		flags |= 1 << 10
 	}
@@ -111,8 +145,8 @@ func (f *Flags) Serialize() uint16 {
 	if f.RA {
 		flags |= 1 << 7
 	}
-	flags |= f.Z << 6
-	flags |= uint16(f.RCODE)
+	flags |= (f.Z & 0x07) << 4
+	flags |= uint16(f.RCODE & 0x0F)
 	return flags
 }
 
@@ -140,7 +174,7 @@ func deserializeFlags(data []byte) *Flags {
 	flags.RD = bitToBool(data[0])
 
 	flags.RA = bitToBool(data[1] >> 7)
-	flags.Z = uint16(data[1] >> 6)
+	flags.Z = uint16((data[1] >> 4) & 0x07)
 	flags.RCODE = ResponseCode(data[1] & 0x0F)
 
 	return flags
@@ -0,0 +1,50 @@
+package dns
+
+import (
+	"testing"
+)
+
+func TestFlagsBitLayout(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags Flags
+		wire  uint16
+	}{
+		{"QR", Flags{QR: true}, 0x8000},
+		{"OPCODE", Flags{OPCODE: OpCodeStatus}, 0x1000},
+		{"largest OPCODE", Flags{OPCODE: 15}, 0x7800},
+		{"AA", Flags{AA: true}, 0x0400},
+		{"TC", Flags{TC: true}, 0x0200},
+		{"RD", Flags{RD: true}, 0x0100},
+		{"RA", Flags{RA: true}, 0x0080},
+		// Z is the three bits between RA and RCODE
+		{"reserved Z bit", Flags{Z: 0x4}, 0x0040},
+		{"AD", Flags{Z: FLAG_Z_AD}, 0x0020},
+		{"CD", Flags{Z: FLAG_Z_CD}, 0x0010},
+		{"RCODE", Flags{RCODE: RCodeRefused}, 0x0005},
+		{"largest RCODE", Flags{RCODE: 15}, 0x000F},
+		{"all", Flags{QR: true, OPCODE: 15, AA: true, TC: true, RD: true, RA: true, Z: 0x7, RCODE: 15}, 0xFFFF},
+		{"response with AD", Flags{QR: true, RD: true, RA: true, Z: FLAG_Z_AD, RCODE: RCodeNameError}, 0x81A3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if wire := test.flags.Serialize(); wire != test.wire {
+				t.Errorf("serialized to %#04x, expected %#04x", wire, test.wire)
+			}
+
+			flags := deserializeFlags([]byte{byte(test.wire >> 8), byte(test.wire)})
+			if *flags != test.flags {
+				t.Errorf("deserialized to %+v, expected %+v", *flags, test.flags)
+			}
+		})
+	}
+}
+
+func TestFlagsSerializeMasksOutOfRangeFields(t *testing.T) {
+	// Fields wider than their bits mustn't spill into their neighbors
+	flags := Flags{OPCODE: 0x1F, Z: 0xFF, RCODE: 0x1F}
+	if wire := flags.Serialize(); wire != 0x787F {
+		t.Errorf("serialized to %#04x, expected %#04x", wire, 0x787F)
+	}
+}
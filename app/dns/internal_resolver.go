@@ -1,5 +1,9 @@
 package dns
 
+import (
+	"net"
+)
+
 type InternalResolver struct {
 }
 
@@ -23,7 +27,7 @@ func (r *InternalResolver) Resolve(request *Message) *Message {
 					Type:  TYPE_A,
 					Class: CLASS_IN,
 					TTL:   60,
-					RData: []byte{8, 8, 8, 8},
+					RData: &AData{IP: net.IPv4(8, 8, 8, 8)},
 				},
 			}
 
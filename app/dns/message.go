@@ -1,25 +1,67 @@
 package dns
 
+import "fmt"
+
+// +---------------------+
+// |        Header       |
+// +---------------------+
+// |       Question      | the question for the name server
+// +---------------------+
+// |        Answer       | RRs answering the question
+// +---------------------+
+// |      Authority      | RRs pointing toward an authority
+// +---------------------+
+// |      Additional     | RRs holding additional information
+// +---------------------+
 type Message struct {
 	Header    Header
 	Questions []Question
 	Answers   []ResourceRecord
+	// Records pointing toward an authoritative name server, e.g. the NS
+	// records of a referral or the SOA record of a negative answer.
+	Authority []ResourceRecord
+	// Records which relate to the query, but are not strictly answers for
+	// the question, e.g. glue addresses for the name servers in Authority.
+	Additional []ResourceRecord
 }
 
 func DeserializeMessage(data []byte) (*Message, error) {
 	message := &Message{}
 
-	header, err := deserializeHeader(data[0:12])
+	header, err := deserializeHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Counts which can't possibly fit into the message are rejected before
+	// anything is allocated for them
+	offset := 12
+	minSize := offset + int(header.QDCOUNT)*MIN_QUESTION_SIZE +
+		(int(header.ANCOUNT)+int(header.NSCOUNT)+int(header.ARCOUNT))*MIN_RESOURCE_RECORD_SIZE
+	if len(data) < minSize {
+		return nil, fmt.Errorf("section counts %d/%d/%d/%d need at least %d bytes, message has %d",
+			header.QDCOUNT, header.ANCOUNT, header.NSCOUNT, header.ARCOUNT, minSize, len(data))
+	}
+
+	bytesRead, questions, err := deserializeQuestions(data, offset, header.QDCOUNT)
 	if err != nil {
 		return nil, err
 	}
+	offset += bytesRead
 
-	bytesRead, questions, err := deserializeQuestions(data, 12, header.QDCOUNT)
+	bytesRead, answers, err := deserializeResourceRecords(data, offset, header.ANCOUNT)
 	if err != nil {
 		return nil, err
 	}
+	offset += bytesRead
 
-	answers, err := deserializeAnswers(data, 12+bytesRead, header.ANCOUNT)
+	bytesRead, authority, err := deserializeResourceRecords(data, offset, header.NSCOUNT)
+	if err != nil {
+		return nil, err
+	}
+	offset += bytesRead
+
+	_, additional, err := deserializeResourceRecords(data, offset, header.ARCOUNT)
 	if err != nil {
 		return nil, err
 	}
@@ -27,27 +69,38 @@ func DeserializeMessage(data []byte) (*Message, error) {
 	message.Header = *header
 	message.Questions = questions
 	message.Answers = answers
+	message.Authority = authority
+	message.Additional = additional
 
 	return message, nil
 }
 
+// Serializes the message into a byte slice. Domain names that repeat within
+// the message are compressed.
 func (m *Message) Serialize() ([]byte, error) {
-	buf := make([]byte, 0)
+	w := newMessageWriter(true)
+
+	w.writeBytes(m.Header.Serialize())
 
-	headerSerialized := m.Header.Serialize()
-	questionsSerialized, err := serializeQuestions(m.Questions)
+	err := serializeQuestions(w, m.Questions)
 	if err != nil {
 		return nil, err
 	}
 
-	answersSerialized, err := serializeAnswers(m.Answers)
+	err = serializeResourceRecords(w, m.Answers)
 	if err != nil {
 		return nil, err
 	}
 
-	buf = append(buf, headerSerialized...)
-	buf = append(buf, questionsSerialized...)
-	buf = append(buf, answersSerialized...)
+	err = serializeResourceRecords(w, m.Authority)
+	if err != nil {
+		return nil, err
+	}
+
+	err = serializeResourceRecords(w, m.Additional)
+	if err != nil {
+		return nil, err
+	}
 
-	return buf, nil
+	return w.bytes(), nil
 }
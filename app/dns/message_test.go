@@ -0,0 +1,182 @@
+package dns
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The seed corpus in testdata/fuzz/FuzzDeserializeMessage holds queries and
+// responses captured from this server and from an upstream resolver.
+func FuzzDeserializeMessage(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkRoundTrip(t, data)
+	})
+}
+
+// Checks that a message survives being serialized: parse, serialize, parse
+// again, and both parses must be equal. Serializing the message again must
+// give the same bytes. Data which doesn't parse is ignored.
+func checkRoundTrip(t *testing.T, data []byte) {
+	t.Helper()
+
+	message, err := DeserializeMessage(data)
+	if err != nil {
+		return
+	}
+
+	serialized, err := message.Serialize()
+	if err != nil {
+		t.Fatalf("failed to serialize parsed message: %v", err)
+	}
+
+	reparsed, err := DeserializeMessage(serialized)
+	if err != nil {
+		t.Fatalf("failed to parse serialized message: %v\n% x", err, serialized)
+	}
+
+	reserialized, err := reparsed.Serialize()
+	if err != nil {
+		t.Fatalf("failed to serialize reparsed message: %v", err)
+	}
+
+	if !bytes.Equal(serialized, reserialized) {
+		t.Fatalf("serialization changed in round trip:\n% x\n% x", serialized, reserialized)
+	}
+
+	// Names are compressed regardless of case, so a name may come back with
+	// the case of an earlier occurrence
+	lowerNames(message)
+	lowerNames(reparsed)
+	if !reflect.DeepEqual(message, reparsed) {
+		t.Fatalf("message changed in round trip:\n%+v\n%+v", message, reparsed)
+	}
+}
+
+func lowerNames(message *Message) {
+	for i := range message.Questions {
+		lowerName(&message.Questions[i].Name)
+	}
+
+	for _, section := range [][]ResourceRecord{message.Answers, message.Authority, message.Additional} {
+		for i := range section {
+			lowerRecordNames(&section[i])
+		}
+	}
+}
+
+func lowerRecordNames(record *ResourceRecord) {
+	lowerName(&record.Name)
+
+	switch data := record.RData.(type) {
+	case *NSData:
+		lowerName(&data.Host)
+	case *CNAMEData:
+		lowerName(&data.Target)
+	case *PTRData:
+		lowerName(&data.Target)
+	case *MXData:
+		lowerName(&data.Exchange)
+	case *SOAData:
+		lowerName(&data.MName)
+		lowerName(&data.RName)
+	case *SRVData:
+		lowerName(&data.Target)
+	case *NameListData:
+		for i := range data.Names {
+			lowerName(&data.Names[i])
+		}
+	}
+}
+
+func lowerName(name *DomainName) {
+	for i, label := range name.Labels {
+		name.Labels[i] = Label(strings.ToLower(string(label)))
+	}
+}
+
+func TestDeserializeMessageRejectsMalformedInput(t *testing.T) {
+	header := []byte{0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	question := []byte{3, 'w', 'w', 'w', 0, 0x00, 0x01, 0x00, 0x01}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"short header", header[:11]},
+		{"missing question", header},
+		{"truncated question", append(header, question[:6]...)},
+		{"pointer to itself", append(header, 0xC0, 12, 0x00, 0x01, 0x00, 0x01)},
+		{"forward pointer", append(header, 0xC0, 18, 0x00, 0x01, 0x00, 0x01, 0)},
+		{"pointer loop", append(header, 1, 'a', 0xC0, 12, 0x00, 0x01, 0x00, 0x01)},
+		{"extended label type", append(header, 0x41, 'a', 0, 0x00, 0x01, 0x00, 0x01)},
+		{"counts exceeding data", []byte{0x12, 0x34, 0x01, 0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DeserializeMessage(test.data)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestMINFONamesSurviveReserialization(t *testing.T) {
+	data := []byte{
+		0x12, 0x34, 0x81, 0x80, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00,
+		// example.com. MINFO IN, at offset 12
+		7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0, 0x00, 0x0E, 0x00, 0x01,
+		// example.com. 60 IN MINFO admin.example.com. errors.example.com.
+		0xC0, 12, 0x00, 0x0E, 0x00, 0x01, 0x00, 0x00, 0x00, 60, 0x00, 17,
+		5, 'a', 'd', 'm', 'i', 'n', 0xC0, 12,
+		6, 'e', 'r', 'r', 'o', 'r', 's', 0xC0, 12,
+	}
+
+	checkRoundTrip(t, data)
+
+	message, err := DeserializeMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Moves every name after the question, so that pointers copied verbatim
+	// would point elsewhere
+	question, err := ParseDomainName("a.longer.example.org.", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message.Questions[0].Name = *question
+
+	serialized, err := message.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reparsed, err := DeserializeMessage(serialized)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "example.com. 60 IN MINFO admin.example.com. errors.example.com."
+	if len(reparsed.Answers) != 1 || reparsed.Answers[0].String() != expected {
+		t.Fatalf("answers are %v, expected %q", reparsed.Answers, expected)
+	}
+}
+
+func TestDeserializeMessageChecksCountsUpFront(t *testing.T) {
+	// A valid question, but far more answers than the rest of the message
+	// could hold
+	data := []byte{
+		0x12, 0x34, 0x81, 0x80, 0x00, 0x01, 0x03, 0xE8, 0x00, 0x00, 0x00, 0x00,
+		3, 'w', 'w', 'w', 0, 0x00, 0x01, 0x00, 0x01,
+	}
+
+	_, err := DeserializeMessage(data)
+	if err == nil || !strings.Contains(err.Error(), "section counts 1/1000/0/0 need at least 11017 bytes") {
+		t.Fatalf("error is %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// The largest offset that fits into the 14 bits of a compression pointer.
+const MAX_POINTER_OFFSET = 0x3FFF
+
+// Accumulates the wire format of a message. When compression is enabled the
+// writer remembers where each domain name suffix was written, so that later
+// occurrences of the same suffix can be replaced with a pointer to it
+// (RFC 1035 section 4.1.4).
+type messageWriter struct {
+	buf []byte
+	// Offsets of the name suffixes written so far, keyed by their lower-cased
+	// dotted form. Nil when compression is disabled.
+	names map[string]int
+}
+
+func newMessageWriter(compress bool) *messageWriter {
+	w := &messageWriter{
+		buf: make([]byte, 0, 512),
+	}
+
+	if compress {
+		w.names = make(map[string]int)
+	}
+
+	return w
+}
+
+func (w *messageWriter) bytes() []byte {
+	return w.buf
+}
+
+func (w *messageWriter) writeBytes(data []byte) {
+	w.buf = append(w.buf, data...)
+}
+
+func (w *messageWriter) writeUint8(value uint8) {
+	w.buf = append(w.buf, value)
+}
+
+func (w *messageWriter) writeUint16(value uint16) {
+	w.buf = binary.BigEndian.AppendUint16(w.buf, value)
+}
+
+func (w *messageWriter) writeUint32(value uint32) {
+	w.buf = binary.BigEndian.AppendUint32(w.buf, value)
+}
+
+// Reserves room for a 16 bit length field and returns its offset, so that it
+// can be filled in with endLength once the data it describes is written.
+func (w *messageWriter) beginLength() int {
+	offset := len(w.buf)
+	w.writeUint16(0)
+	return offset
+}
+
+func (w *messageWriter) endLength(lengthOffset int) error {
+	length := len(w.buf) - lengthOffset - 2
+	if length > 0xFFFF {
+		return fmt.Errorf("data length %d exceeds maximum of 65535", length)
+	}
+
+	binary.BigEndian.PutUint16(w.buf[lengthOffset:], uint16(length))
+	return nil
+}
+
+// Discards everything written from offset onwards, including the names that
+// later names could have pointed to.
+func (w *messageWriter) rollback(offset int) {
+	w.buf = w.buf[:offset]
+
+	for suffix, nameOffset := range w.names {
+		if nameOffset >= offset {
+			delete(w.names, suffix)
+		}
+	}
+}
+
+// Writes a domain name, replacing the longest suffix that has already been
+// written with a pointer to it.
+func (w *messageWriter) writeDomainName(d *DomainName) error {
+	for i, label := range d.Labels {
+		labelLength := len(label)
+		// The high order two bits of every length octet must be zero, and the
+		// remaining six bits of the length field limit the label to 63 octets or
+		// less.
+		if labelLength > MAX_LABEL_LENGTH {
+			return fmt.Errorf("label length %d exceeds maximum of 63", labelLength)
+		}
+		if labelLength == 0 {
+			return fmt.Errorf("empty label in domain name")
+		}
+
+		if w.names != nil {
+			suffix := nameKey(d.Labels[i:])
+			if pointerOffset, ok := w.names[suffix]; ok {
+				w.writeUint16(0xC000 | uint16(pointerOffset))
+				return nil
+			}
+
+			// Only offsets that fit into a pointer can be referred to later
+			if len(w.buf) <= MAX_POINTER_OFFSET {
+				w.names[suffix] = len(w.buf)
+			}
+		}
+
+		w.writeUint8(byte(labelLength))
+		w.writeBytes([]byte(label))
+	}
+
+	w.writeUint8(0)
+
+	return nil
+}
+
+// Writes a domain name in full, for the few places where compression is not
+// allowed. Its suffixes can still be pointed to by later names.
+func (w *messageWriter) writeDomainNameUncompressed(d *DomainName) error {
+	names := w.names
+	if names != nil {
+		w.names = make(map[string]int)
+	}
+
+	err := w.writeDomainName(d)
+	if err != nil {
+		return err
+	}
+
+	for suffix, offset := range w.names {
+		names[suffix] = offset
+	}
+	w.names = names
+
+	return nil
+}
+
+// Domain names are compared case-insensitively.
+func nameKey(labels []Label) string {
+	parts := make([]string, len(labels))
+	for i, label := range labels {
+		parts[i] = strings.ToLower(string(label))
+	}
+
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,73 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+)
+
+// A stage of a resolver chain, in the style of CoreDNS plugins. A middleware
+// wraps the next resolver of the chain and may answer requests itself,
+// rewrite them before passing them on, or change the responses of the next
+// resolver.
+type Middleware func(next ContextResolver) (ContextResolver, error)
+
+// Wraps the resolver in the middlewares. Requests pass through the
+// middlewares in the order given, so the first one sees them first and the
+// resolver last.
+func Chain(resolver ContextResolver, middlewares ...Middleware) (ContextResolver, error) {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		next, err := middlewares[i](resolver)
+		if err != nil {
+			return nil, err
+		}
+
+		resolver = next
+	}
+
+	return resolver, nil
+}
+
+// A middleware built from a function, for stages too small for a type of
+// their own. handle is called for every request and decides whether and how
+// to call next.
+func MiddlewareFunc(handle func(ctx context.Context, info *RequestInfo, msg *Message, next ContextResolver) (*Message, error)) Middleware {
+	return func(next ContextResolver) (ContextResolver, error) {
+		if next == nil {
+			return nil, fmt.Errorf("middleware has no resolver to wrap")
+		}
+
+		return &middlewareFuncResolver{handle: handle, next: next}, nil
+	}
+}
+
+type middlewareFuncResolver struct {
+	handle func(ctx context.Context, info *RequestInfo, msg *Message, next ContextResolver) (*Message, error)
+	next   ContextResolver
+}
+
+func (r *middlewareFuncResolver) ResolveContext(ctx context.Context, info *RequestInfo, msg *Message) (*Message, error) {
+	return r.handle(ctx, info, msg, r.next)
+}
+
+func (r *middlewareFuncResolver) Resolve(msg *Message) *Message {
+	return resolveWithoutContext(r, msg)
+}
+
+// Leaves out the authority and additional sections of positive answers, which
+// clients rarely need, to keep responses small (like minimal-responses in
+// BIND). Negative answers keep the SOA record proving them.
+var MinimalResponses = MiddlewareFunc(func(ctx context.Context, info *RequestInfo, msg *Message, next ContextResolver) (*Message, error) {
+	response, err := next.ResolveContext(ctx, info, msg)
+	if err != nil || response.Header.Flags.RCODE != RCodeNoError || len(response.Answers) == 0 {
+		return response, err
+	}
+
+	edns := response.EDNS()
+	response.Authority = make([]ResourceRecord, 0)
+	response.Additional = make([]ResourceRecord, 0)
+	response.Header.NSCOUNT = 0
+	response.Header.ARCOUNT = 0
+	response.SetEDNS(edns)
+
+	return response, nil
+})
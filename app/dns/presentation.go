@@ -0,0 +1,151 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The bits of Flags.Z which DNSSEC gave a meaning (RFC 4035 section 3.2).
+const (
+	// Authentic Data. The response has been validated.
+	FLAG_Z_AD = 0x2
+	// Checking Disabled. The requester will validate the response itself.
+	FLAG_Z_CD = 0x1
+)
+
+// Returns the question in presentation format, e.g. "example.com. IN A".
+func (q *Question) String() string {
+	return fmt.Sprintf("%s %s %s", q.Name.String(), q.Class, q.Type)
+}
+
+// Returns the record in presentation format, as used in master files, e.g.
+// "example.com. 60 IN A 192.0.2.1".
+func (r *ResourceRecord) String() string {
+	return strings.Join(r.fields(), " ")
+}
+
+// Returns the owner, TTL, class, type and RDATA of the record in
+// presentation format.
+func (r *ResourceRecord) fields() []string {
+	rData := ""
+	if r.RData != nil {
+		rData = r.RData.String()
+	}
+
+	return []string{r.Name.String(), fmt.Sprint(r.TTL), r.Class.String(), r.Type.String(), rData}
+}
+
+// Parses a record in presentation format, as returned by
+// ResourceRecord.String. The owner, TTL and type are required, the class
+// defaults to IN. Relative names are relative to origin, which may be nil if
+// only absolute names are expected.
+func ParseResourceRecord(text string, origin *DomainName) (*ResourceRecord, error) {
+	lines, err := tokenizeZone(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lines) != 1 {
+		return nil, fmt.Errorf("expected a single record, got %d", len(lines))
+	}
+
+	line := lines[0]
+	if line.indented {
+		return nil, fmt.Errorf("record without an owner")
+	}
+
+	parser := &zoneParser{
+		origin:    origin,
+		lastClass: CLASS_IN,
+	}
+
+	err = parser.parseRecord(&line)
+	if err != nil {
+		return nil, err
+	}
+
+	return &parser.records[0], nil
+}
+
+// Returns the message in the layout dig prints it in: the header and its
+// flags, the EDNS parameters if there are any, followed by the sections.
+func (m *Message) String() string {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, ";; ->>HEADER<<- opcode: %s, status: %s, id: %d\n",
+		m.Header.Flags.OPCODE, m.ResponseCode(), m.Header.ID)
+
+	flags := ""
+	for _, name := range m.Header.Flags.Names() {
+		flags += " " + name
+	}
+	fmt.Fprintf(&builder, ";; flags:%s; QUERY: %d, ANSWER: %d, AUTHORITY: %d, ADDITIONAL: %d\n",
+		flags, m.Header.QDCOUNT, m.Header.ANCOUNT, m.Header.NSCOUNT, m.Header.ARCOUNT)
+
+	if edns := m.EDNS(); edns != nil {
+		builder.WriteString("\n;; OPT PSEUDOSECTION:\n")
+		ednsFlags := ""
+		if edns.DO {
+			ednsFlags = " do"
+		}
+		fmt.Fprintf(&builder, "; EDNS: version: %d, flags:%s; udp: %d\n", edns.Version, ednsFlags, edns.UDPSize)
+
+		for _, option := range edns.Options {
+			fmt.Fprintf(&builder, "; OPTION %d: %x\n", option.Code, option.Data)
+		}
+	}
+
+	if len(m.Questions) > 0 {
+		builder.WriteString("\n;; QUESTION SECTION:\n")
+		for _, question := range m.Questions {
+			fmt.Fprintf(&builder, ";%s\t\t%s\t%s\n", question.Name.String(), question.Class, question.Type)
+		}
+	}
+
+	writeSection := func(title string, records []ResourceRecord) {
+		first := true
+		for _, record := range records {
+			// The OPT record is shown as the pseudosection above
+			if record.Type == TYPE_OPT {
+				continue
+			}
+
+			if first {
+				fmt.Fprintf(&builder, "\n;; %s SECTION:\n", title)
+				first = false
+			}
+
+			builder.WriteString(strings.Join(record.fields(), "\t"))
+			builder.WriteString("\n")
+		}
+	}
+
+	writeSection("ANSWER", m.Answers)
+	writeSection("AUTHORITY", m.Authority)
+	writeSection("ADDITIONAL", m.Additional)
+
+	return builder.String()
+}
+
+// Returns the names of the flags which are set, as dig shows them.
+func (f *Flags) Names() []string {
+	names := make([]string, 0)
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{
+		{f.QR, "qr"},
+		{f.AA, "aa"},
+		{f.TC, "tc"},
+		{f.RD, "rd"},
+		{f.RA, "ra"},
+		{f.Z&FLAG_Z_AD != 0, "ad"},
+		{f.Z&FLAG_Z_CD != 0, "cd"},
+	} {
+		if flag.set {
+			names = append(names, flag.name)
+		}
+	}
+
+	return names
+}
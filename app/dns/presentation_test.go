@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestResourceRecordPresentationRoundTrip(t *testing.T) {
+	records := []string{
+		"www.example.com. 60 IN A 192.0.2.10",
+		"www.example.com. 60 IN AAAA 2001:db8::10",
+		"mapped.example.com. 60 IN AAAA ::ffff:192.0.2.10",
+		"example.com. 3600 IN NS ns1.example.com.",
+		"alias.example.com. 300 IN CNAME www.example.com.",
+		"10.2.0.192.in-addr.arpa. 300 IN PTR www.example.com.",
+		"example.com. 3600 IN MX 10 mail.example.com.",
+		`txt.example.com. 60 IN TXT "hello world" "a\"b" "\000\255"`,
+		"example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 2024010101 7200 3600 1209600 300",
+		"_sip._tcp.example.com. 60 IN SRV 10 5 5060 www.example.com.",
+		"example.com. 60 IN MB mail.example.com.",
+		"list.example.com. 60 IN MINFO admin.example.com. errors.example.com.",
+		`unknown.example.com. 60 CH TYPE65280 \# 3 abcdef`,
+	}
+
+	for _, text := range records {
+		t.Run(text, func(t *testing.T) {
+			record, err := ParseResourceRecord(text, nil)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+
+			if record.String() != text {
+				t.Fatalf("printed as %q", record.String())
+			}
+		})
+	}
+}
+
+func TestParseResourceRecordUsesOrigin(t *testing.T) {
+	origin, err := ParseDomainName("example.com.", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := ParseResourceRecord("www 1h MX 10 mail", origin)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	expected := "www.example.com. 3600 IN MX 10 mail.example.com."
+	if record.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, record.String())
+	}
+}
+
+func TestMessageString(t *testing.T) {
+	name, err := ParseDomainName("www.example.com.", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := &Message{
+		Header: Header{
+			ID:    4660,
+			Flags: Flags{QR: true, AA: true, RD: true, RCODE: RCodeNoError},
+		},
+		Questions: []Question{{Name: *name, Type: TYPE_A, Class: CLASS_IN}},
+		Answers: []ResourceRecord{{
+			Name:  *name,
+			Type:  TYPE_A,
+			Class: CLASS_IN,
+			TTL:   60,
+			RData: &AData{IP: net.IPv4(192, 0, 2, 10).To4()},
+		}},
+	}
+	message.Header.QDCOUNT = 1
+	message.Header.ANCOUNT = 1
+
+	expected := []string{
+		";; ->>HEADER<<- opcode: QUERY, status: NOERROR, id: 4660",
+		";; flags: qr aa rd; QUERY: 1, ANSWER: 1, AUTHORITY: 0, ADDITIONAL: 0",
+		";; QUESTION SECTION:",
+		";www.example.com.\t\tIN\tA",
+		";; ANSWER SECTION:",
+		"www.example.com.\t60\tIN\tA\t192.0.2.10",
+	}
+
+	output := message.String()
+	for _, line := range expected {
+		if !strings.Contains(output, line+"\n") {
+			t.Errorf("missing line %q in:\n%s", line, output)
+		}
+	}
+}
@@ -1,5 +1,8 @@
 package dns
 
+// The smallest question on the wire: the root name, QTYPE and QCLASS.
+const MIN_QUESTION_SIZE = 1 + 2 + 2
+
 //	                              1  1  1  1  1  1
 //	0  1  2  3  4  5  6  7  8  9  0  1  2  3  4  5
 //
@@ -20,39 +23,46 @@ type Question struct {
 	Class ResourceRecordClass
 }
 
+// Reports whether both questions ask for the same name, type and class. Names
+// are compared case-insensitively.
+func (q *Question) Equal(other *Question) bool {
+	return q.Type == other.Type && q.Class == other.Class && q.Name.Equal(&other.Name)
+}
+
 // Serializes the question into a byte slice.
 func (q *Question) Serialize() ([]byte, error) {
-	buf := make([]byte, 0)
+	w := newMessageWriter(false)
 
-	domainNameSerialized, err := q.Name.Serialize()
+	err := q.serializeTo(w)
 	if err != nil {
 		return nil, err
 	}
 
-	typeSerialized := uint16ToBytes(uint16(q.Type))
-	classSerialized := uint16ToBytes(uint16(q.Class))
+	return w.bytes(), nil
+}
 
-	buf = append(buf, domainNameSerialized...)
-	buf = append(buf, typeSerialized...)
-	buf = append(buf, classSerialized...)
+func (q *Question) serializeTo(w *messageWriter) error {
+	err := w.writeDomainName(&q.Name)
+	if err != nil {
+		return err
+	}
 
-	return buf, nil
-}
+	w.writeUint16(uint16(q.Type))
+	w.writeUint16(uint16(q.Class))
 
-// Serializes a slice of questions into a byte slice.
-func serializeQuestions(questions []Question) ([]byte, error) {
-	buf := make([]byte, 0)
+	return nil
+}
 
+// Serializes a slice of questions into the message.
+func serializeQuestions(w *messageWriter, questions []Question) error {
 	for _, question := range questions {
-		questionSerialized, err := question.Serialize()
+		err := question.serializeTo(w)
 		if err != nil {
-			return nil, err
+			return err
 		}
-
-		buf = append(buf, questionSerialized...)
 	}
 
-	return buf, nil
+	return nil
 }
 
 func deserializeQuestion(buf []byte, offset int) (int, *Question, error) {
@@ -0,0 +1,154 @@
+package dns
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// The most clients whose request rates are tracked at the same time. Once
+// there are more, the client which hasn't sent a request for the longest
+// time is forgotten.
+const RATE_LIMIT_MAX_CLIENTS = 65536
+
+type RateLimitOptions struct {
+	// The number of requests per second a client may send on average.
+	Rate float64
+	// The number of requests a client may send in a burst, above the rate.
+	Burst int
+}
+
+// Wraps another resolver to refuse requests of clients sending more than
+// their share. Every client has a bucket of tokens, which refills at the
+// configured rate and holds at most Burst tokens; every request takes a
+// token, and requests finding the bucket empty are refused.
+type RateLimitResolver struct {
+	next    ContextResolver
+	options RateLimitOptions
+	// RATE_LIMIT_MAX_CLIENTS, unless a test lowers it.
+	maxClients int
+
+	mutex sync.Mutex
+	// Buckets ordered from the most to the least recently used, so that a
+	// flood of new clients only pushes out the clients which have gone
+	// quiet, rather than those still sending.
+	lru     *list.List
+	buckets map[string]*list.Element
+}
+
+type tokenBucket struct {
+	client    string
+	tokens    float64
+	updatedAt time.Time
+	// Whether the client is being limited, from its first refused request
+	// until its bucket has refilled, and the number of requests refused
+	// meanwhile. A flood is thus reported once rather than for every request.
+	limited bool
+	refused int
+}
+
+// How taking a token changed whether the client is limited.
+type rateLimitChange int
+
+const (
+	rateLimitUnchanged rateLimitChange = iota
+	// A request of the client was refused for the first time since it was
+	// last limited.
+	rateLimitStarted
+	// The bucket of the limited client has refilled, so it has stopped
+	// sending more than its share.
+	rateLimitEnded
+)
+
+func InitRateLimitResolver(next ContextResolver, options RateLimitOptions) (*RateLimitResolver, error) {
+	if options.Rate <= 0 {
+		return nil, fmt.Errorf("rate limit must be positive, got %v", options.Rate)
+	}
+
+	if options.Burst < 1 {
+		return nil, fmt.Errorf("rate limit burst must be at least 1, got %d", options.Burst)
+	}
+
+	return &RateLimitResolver{
+		next:       next,
+		options:    options,
+		maxClients: RATE_LIMIT_MAX_CLIENTS,
+		lru:        list.New(),
+		buckets:    make(map[string]*list.Element),
+	}, nil
+}
+
+func (r *RateLimitResolver) Resolve(request *Message) *Message {
+	return resolveWithoutContext(r, request)
+}
+
+func (r *RateLimitResolver) ResolveContext(ctx context.Context, info *RequestInfo, request *Message) (*Message, error) {
+	client := info.ClientIP()
+	if client != nil {
+		allowed, change, refused := r.take(client.String(), time.Now())
+		switch change {
+		case rateLimitStarted:
+			fmt.Println("Rate limiting client", client)
+		case rateLimitEnded:
+			fmt.Println("No longer rate limiting client", client, "after refusing", refused, "requests")
+		}
+
+		if !allowed {
+			return MakeErrorResponse(request, RCodeRefused), nil
+		}
+	}
+
+	return r.next.ResolveContext(ctx, info, request)
+}
+
+// Takes a token from the bucket of the client, if there is one left. Returns
+// whether there was, and how that changed whether the client is limited. Once
+// the client is no longer limited, the number of requests refused meanwhile
+// is returned as well.
+func (r *RateLimitResolver) take(client string, now time.Time) (bool, rateLimitChange, int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var bucket *tokenBucket
+	if element, ok := r.buckets[client]; ok {
+		bucket = element.Value.(*tokenBucket)
+		r.lru.MoveToFront(element)
+	} else {
+		if r.lru.Len() >= r.maxClients {
+			oldest := r.lru.Back()
+			delete(r.buckets, oldest.Value.(*tokenBucket).client)
+			r.lru.Remove(oldest)
+		}
+
+		bucket = &tokenBucket{client: client, tokens: float64(r.options.Burst), updatedAt: now}
+		r.buckets[client] = r.lru.PushFront(bucket)
+	}
+
+	elapsed := now.Sub(bucket.updatedAt).Seconds()
+	bucket.tokens = math.Min(bucket.tokens+elapsed*r.options.Rate, float64(r.options.Burst))
+	bucket.updatedAt = now
+
+	if bucket.tokens < 1 {
+		bucket.refused++
+		if !bucket.limited {
+			bucket.limited = true
+			return false, rateLimitStarted, 0
+		}
+
+		return false, rateLimitUnchanged, 0
+	}
+
+	change := rateLimitUnchanged
+	refused := 0
+	if bucket.limited && bucket.tokens >= float64(r.options.Burst) {
+		change, refused = rateLimitEnded, bucket.refused
+		bucket.limited = false
+		bucket.refused = 0
+	}
+
+	bucket.tokens--
+	return true, change, refused
+}
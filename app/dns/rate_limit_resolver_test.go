@@ -0,0 +1,84 @@
+package dns
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRateLimitResolverReportsLimitingOnce(t *testing.T) {
+	r, err := InitRateLimitResolver(nil, RateLimitOptions{Rate: 1, Burst: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	expect := func(allowed bool, change rateLimitChange, refused int) {
+		t.Helper()
+
+		gotAllowed, gotChange, gotRefused := r.take("192.0.2.1", now)
+		if gotAllowed != allowed || gotChange != change || gotRefused != refused {
+			t.Fatalf("take returned %v, %d, %d, expected %v, %d, %d",
+				gotAllowed, gotChange, gotRefused, allowed, change, refused)
+		}
+	}
+
+	// The burst, then refusals of which only the first is reported
+	expect(true, rateLimitUnchanged, 0)
+	expect(true, rateLimitUnchanged, 0)
+	expect(false, rateLimitStarted, 0)
+	expect(false, rateLimitUnchanged, 0)
+
+	// Another client has its own bucket
+	if allowed, change, _ := r.take("192.0.2.2", now); !allowed || change != rateLimitUnchanged {
+		t.Fatal("other client was limited")
+	}
+
+	// A client flooding at more than the rate stays limited, even though
+	// the refill allows some of its requests
+	for i := 0; i < 3; i++ {
+		now = now.Add(time.Second)
+		expect(true, rateLimitUnchanged, 0)
+		expect(false, rateLimitUnchanged, 0)
+	}
+
+	// Once the bucket has refilled the client is no longer limited
+	now = now.Add(2 * time.Second)
+	expect(true, rateLimitEnded, 5)
+	expect(true, rateLimitUnchanged, 0)
+	expect(false, rateLimitStarted, 0)
+}
+
+func TestRateLimitResolverKeepsLimitingDuringFloodOfClients(t *testing.T) {
+	r, err := InitRateLimitResolver(nil, RateLimitOptions{Rate: 1, Burst: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.maxClients = 4
+
+	now := time.Unix(1700000000, 0)
+	r.take("192.0.2.1", now)
+	r.take("192.0.2.2", now)
+
+	// Requests from ever new, e.g. spoofed, addresses must not reset the
+	// bucket of the client which keeps sending
+	for i := 0; i < 100; i++ {
+		r.take(fmt.Sprintf("198.51.100.%d", i), now)
+
+		if allowed, _, _ := r.take("192.0.2.1", now); allowed {
+			t.Fatalf("client was allowed again after %d new clients", i+1)
+		}
+	}
+
+	if len(r.buckets) != r.maxClients || r.lru.Len() != r.maxClients {
+		t.Errorf("tracking %d and %d clients, expected %d", len(r.buckets), r.lru.Len(), r.maxClients)
+	}
+
+	// The client which went quiet was forgotten, so it starts over
+	if _, ok := r.buckets["192.0.2.2"]; ok {
+		t.Error("the least recently seen client is still tracked")
+	}
+	if allowed, _, _ := r.take("192.0.2.2", now); !allowed {
+		t.Error("forgotten client was refused")
+	}
+}
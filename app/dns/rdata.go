@@ -0,0 +1,412 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+)
+
+// The type specific data of a resource record, e.g. the address of an A
+// record or the exchange of an MX record.
+type RecordData interface {
+	// Returns the RDATA in presentation format, as used in master files.
+	String() string
+	serializeTo(w *messageWriter) error
+}
+
+// The address of a host (RFC 1035 section 3.4.1).
+type AData struct {
+	IP net.IP
+}
+
+// The IPv6 address of a host (RFC 3596 section 2.2).
+type AAAAData struct {
+	IP net.IP
+}
+
+// A host which should be authoritative for the specified class and domain
+// (RFC 1035 section 3.3.11).
+type NSData struct {
+	Host DomainName
+}
+
+// The canonical or primary name for the owner, the owner name is an alias
+// (RFC 1035 section 3.3.1).
+type CNAMEData struct {
+	Target DomainName
+}
+
+// A pointer to some location in the domain name space (RFC 1035 section
+// 3.3.12).
+type PTRData struct {
+	Target DomainName
+}
+
+// A host willing to act as a mail exchange for the owner name (RFC 1035
+// section 3.3.9).
+type MXData struct {
+	// The preference given to this RR among others at the same owner. Lower
+	// values are preferred.
+	Preference uint16
+	Exchange   DomainName
+}
+
+// One or more character strings of descriptive text (RFC 1035 section
+// 3.3.14). Each string is at most 255 octets long.
+type TXTData struct {
+	Strings []string
+}
+
+// Marks the start of a zone of authority (RFC 1035 section 3.3.13).
+type SOAData struct {
+	// The name server that was the original or primary source of data for
+	// this zone.
+	MName DomainName
+	// The mailbox of the person responsible for this zone.
+	RName DomainName
+	// The version number of the original copy of the zone.
+	Serial uint32
+	// Time interval before the zone should be refreshed.
+	Refresh uint32
+	// Time interval that should elapse before a failed refresh should be
+	// retried.
+	Retry uint32
+	// Time value that specifies the upper limit on the time interval that
+	// can elapse before the zone is no longer authoritative.
+	Expire uint32
+	// The minimum TTL field that should be exported with any RR from this
+	// zone.
+	Minimum uint32
+}
+
+// The location of the server(s) for a specific protocol and domain (RFC 2782).
+type SRVData struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   DomainName
+}
+
+// The domain names which make up the RDATA of the obsolete and experimental
+// mail records of RFC 1035: MD, MF, MB, MG and MR hold a single name, MINFO
+// holds RMAILBX and EMAILBX.
+type NameListData struct {
+	Names []DomainName
+}
+
+// The number of domain names in the RDATA of the types held by NameListData.
+var nameListTypes = map[ResourceRecordType]int{
+	TYPE_MD:    1,
+	TYPE_MF:    1,
+	TYPE_MB:    1,
+	TYPE_MG:    1,
+	TYPE_MR:    1,
+	TYPE_MINFO: 2,
+}
+
+// The RDATA of a record type this package has no typed model for, kept as
+// opaque bytes (RFC 3597).
+type RawData struct {
+	Data []byte
+}
+
+func (d *AData) serializeTo(w *messageWriter) error {
+	ip := d.IP.To4()
+	if ip == nil {
+		return fmt.Errorf("invalid IPv4 address %v", d.IP)
+	}
+
+	w.writeBytes(ip)
+	return nil
+}
+
+func (d *AAAAData) serializeTo(w *messageWriter) error {
+	ip := d.IP.To16()
+	if ip == nil {
+		return fmt.Errorf("invalid IPv6 address %v", d.IP)
+	}
+
+	w.writeBytes(ip)
+	return nil
+}
+
+func (d *NSData) serializeTo(w *messageWriter) error {
+	return w.writeDomainName(&d.Host)
+}
+
+func (d *CNAMEData) serializeTo(w *messageWriter) error {
+	return w.writeDomainName(&d.Target)
+}
+
+func (d *PTRData) serializeTo(w *messageWriter) error {
+	return w.writeDomainName(&d.Target)
+}
+
+func (d *MXData) serializeTo(w *messageWriter) error {
+	w.writeUint16(d.Preference)
+	return w.writeDomainName(&d.Exchange)
+}
+
+func (d *TXTData) serializeTo(w *messageWriter) error {
+	for _, str := range d.Strings {
+		// <character-string> is a single length octet followed by that number
+		// of characters.
+		if len(str) > 255 {
+			return fmt.Errorf("TXT string length %d exceeds maximum of 255", len(str))
+		}
+
+		w.writeUint8(byte(len(str)))
+		w.writeBytes([]byte(str))
+	}
+
+	return nil
+}
+
+func (d *SOAData) serializeTo(w *messageWriter) error {
+	err := w.writeDomainName(&d.MName)
+	if err != nil {
+		return err
+	}
+
+	err = w.writeDomainName(&d.RName)
+	if err != nil {
+		return err
+	}
+
+	w.writeUint32(d.Serial)
+	w.writeUint32(d.Refresh)
+	w.writeUint32(d.Retry)
+	w.writeUint32(d.Expire)
+	w.writeUint32(d.Minimum)
+
+	return nil
+}
+
+func (d *SRVData) serializeTo(w *messageWriter) error {
+	w.writeUint16(d.Priority)
+	w.writeUint16(d.Weight)
+	w.writeUint16(d.Port)
+
+	// The target name must not be compressed (RFC 2782)
+	return w.writeDomainNameUncompressed(&d.Target)
+}
+
+func (d *NameListData) serializeTo(w *messageWriter) error {
+	for i := range d.Names {
+		err := w.writeDomainName(&d.Names[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *RawData) serializeTo(w *messageWriter) error {
+	w.writeBytes(d.Data)
+	return nil
+}
+
+// Reads the RDATA of a record of the given type, which occupies length bytes
+// starting at offset. Domain names within the RDATA may point to anywhere
+// before it in buf.
+func deserializeRecordData(buf []byte, offset int, length int, rrType ResourceRecordType) (RecordData, error) {
+	if len(buf) < offset+length {
+		return nil, fmt.Errorf("not enough bytes to read RDATA")
+	}
+
+	// Names and fields must not extend past the end of the RDATA
+	end := offset + length
+	rData := buf[:end]
+
+	var data RecordData
+	var bytesRead int
+	var err error
+
+	switch rrType {
+	case TYPE_A:
+		bytesRead, data, err = deserializeAData(rData, offset)
+	case TYPE_AAAA:
+		bytesRead, data, err = deserializeAAAAData(rData, offset)
+	case TYPE_NS:
+		var host *DomainName
+		bytesRead, host, err = deserializeDomainName(rData, offset)
+		if err == nil {
+			data = &NSData{Host: *host}
+		}
+	case TYPE_CNAME:
+		var target *DomainName
+		bytesRead, target, err = deserializeDomainName(rData, offset)
+		if err == nil {
+			data = &CNAMEData{Target: *target}
+		}
+	case TYPE_PTR:
+		var target *DomainName
+		bytesRead, target, err = deserializeDomainName(rData, offset)
+		if err == nil {
+			data = &PTRData{Target: *target}
+		}
+	case TYPE_MX:
+		bytesRead, data, err = deserializeMXData(rData, offset)
+	case TYPE_TXT:
+		bytesRead, data, err = deserializeTXTData(rData, offset)
+	case TYPE_SOA:
+		bytesRead, data, err = deserializeSOAData(rData, offset)
+	case TYPE_SRV:
+		bytesRead, data, err = deserializeSRVData(rData, offset)
+	case TYPE_OPT:
+		bytesRead, data, err = deserializeOPTData(rData, offset)
+	case TYPE_MD, TYPE_MF, TYPE_MB, TYPE_MG, TYPE_MR, TYPE_MINFO:
+		bytesRead, data, err = deserializeNameListData(rData, offset, nameListTypes[rrType])
+	default:
+		raw := make([]byte, length)
+		copy(raw, rData[offset:])
+		bytesRead, data = length, &RawData{Data: raw}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bytesRead != length {
+		return nil, fmt.Errorf("RDATA of type %d has %d trailing bytes", rrType, length-bytesRead)
+	}
+
+	return data, nil
+}
+
+func deserializeAData(buf []byte, offset int) (int, *AData, error) {
+	if len(buf) < offset+net.IPv4len {
+		return 0, nil, fmt.Errorf("not enough bytes to read IPv4 address")
+	}
+
+	ip := make(net.IP, net.IPv4len)
+	copy(ip, buf[offset:])
+
+	return net.IPv4len, &AData{IP: ip}, nil
+}
+
+func deserializeAAAAData(buf []byte, offset int) (int, *AAAAData, error) {
+	if len(buf) < offset+net.IPv6len {
+		return 0, nil, fmt.Errorf("not enough bytes to read IPv6 address")
+	}
+
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, buf[offset:])
+
+	return net.IPv6len, &AAAAData{IP: ip}, nil
+}
+
+func deserializeMXData(buf []byte, offset int) (int, *MXData, error) {
+	if len(buf) < offset+2 {
+		return 0, nil, fmt.Errorf("not enough bytes to read MX preference")
+	}
+
+	preference, err := uint16FromBytes(buf[offset : offset+2])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	bytesRead, exchange, err := deserializeDomainName(buf, offset+2)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return 2 + bytesRead, &MXData{
+		Preference: preference,
+		Exchange:   *exchange,
+	}, nil
+}
+
+func deserializeTXTData(buf []byte, offset int) (int, *TXTData, error) {
+	startOffset := offset
+	strings := make([]string, 0)
+
+	for offset < len(buf) {
+		length := int(buf[offset])
+		offset += 1
+
+		if len(buf) < offset+length {
+			return 0, nil, fmt.Errorf("not enough bytes to read TXT string")
+		}
+
+		strings = append(strings, string(buf[offset:offset+length]))
+		offset += length
+	}
+
+	return offset - startOffset, &TXTData{Strings: strings}, nil
+}
+
+func deserializeSOAData(buf []byte, offset int) (int, *SOAData, error) {
+	startOffset := offset
+
+	bytesRead, mName, err := deserializeDomainName(buf, offset)
+	if err != nil {
+		return 0, nil, err
+	}
+	offset += bytesRead
+
+	bytesRead, rName, err := deserializeDomainName(buf, offset)
+	if err != nil {
+		return 0, nil, err
+	}
+	offset += bytesRead
+
+	if len(buf) < offset+20 {
+		return 0, nil, fmt.Errorf("not enough bytes to read SOA timers")
+	}
+
+	soa := &SOAData{
+		MName: *mName,
+		RName: *rName,
+	}
+
+	for _, field := range []*uint32{&soa.Serial, &soa.Refresh, &soa.Retry, &soa.Expire, &soa.Minimum} {
+		*field, err = uint32FromBytes(buf[offset : offset+4])
+		if err != nil {
+			return 0, nil, err
+		}
+		offset += 4
+	}
+
+	return offset - startOffset, soa, nil
+}
+
+func deserializeNameListData(buf []byte, offset int, count int) (int, *NameListData, error) {
+	startOffset := offset
+	names := make([]DomainName, 0, count)
+
+	for i := 0; i < count; i++ {
+		bytesRead, name, err := deserializeDomainName(buf, offset)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		names = append(names, *name)
+		offset += bytesRead
+	}
+
+	return offset - startOffset, &NameListData{Names: names}, nil
+}
+
+func deserializeSRVData(buf []byte, offset int) (int, *SRVData, error) {
+	if len(buf) < offset+6 {
+		return 0, nil, fmt.Errorf("not enough bytes to read SRV fields")
+	}
+
+	srv := &SRVData{}
+	for i, field := range []*uint16{&srv.Priority, &srv.Weight, &srv.Port} {
+		value, err := uint16FromBytes(buf[offset+2*i : offset+2*i+2])
+		if err != nil {
+			return 0, nil, err
+		}
+		*field = value
+	}
+
+	bytesRead, target, err := deserializeDomainName(buf, offset+6)
+	if err != nil {
+		return 0, nil, err
+	}
+	srv.Target = *target
+
+	return 6 + bytesRead, srv, nil
+}
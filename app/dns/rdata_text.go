@@ -0,0 +1,418 @@
+package dns
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Parses the RDATA of a record in master file format. Relative domain names
+// are relative to origin.
+func parseRecordData(rrType ResourceRecordType, tokens []zoneToken, origin *DomainName) (RecordData, error) {
+	// Any type may use the generic format of RFC 3597 section 5
+	if len(tokens) > 0 && tokens[0].text == `\#` && !tokens[0].quoted {
+		return parseGenericRecordData(rrType, tokens[1:])
+	}
+
+	switch rrType {
+	case TYPE_A:
+		ip, err := parseIP(tokens, false)
+		if err != nil {
+			return nil, err
+		}
+
+		return &AData{IP: ip}, nil
+	case TYPE_AAAA:
+		ip, err := parseIP(tokens, true)
+		if err != nil {
+			return nil, err
+		}
+
+		return &AAAAData{IP: ip}, nil
+	case TYPE_NS:
+		names, err := parseDomainNames(tokens, origin, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return &NSData{Host: *names[0]}, nil
+	case TYPE_CNAME:
+		names, err := parseDomainNames(tokens, origin, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return &CNAMEData{Target: *names[0]}, nil
+	case TYPE_PTR:
+		names, err := parseDomainNames(tokens, origin, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return &PTRData{Target: *names[0]}, nil
+	case TYPE_MX:
+		if len(tokens) != 2 {
+			return nil, fmt.Errorf("expected a preference and an exchange")
+		}
+
+		preference, err := parseUint16(tokens[0].text)
+		if err != nil {
+			return nil, err
+		}
+
+		names, err := parseDomainNames(tokens[1:], origin, 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return &MXData{Preference: preference, Exchange: *names[0]}, nil
+	case TYPE_TXT:
+		if len(tokens) == 0 {
+			return nil, fmt.Errorf("expected at least one string")
+		}
+
+		strings := make([]string, 0, len(tokens))
+		for _, token := range tokens {
+			str, err := unescapeCharacterString(token.text)
+			if err != nil {
+				return nil, err
+			}
+
+			strings = append(strings, str)
+		}
+
+		return &TXTData{Strings: strings}, nil
+	case TYPE_SOA:
+		if len(tokens) != 7 {
+			return nil, fmt.Errorf("expected MNAME, RNAME, SERIAL, REFRESH, RETRY, EXPIRE and MINIMUM")
+		}
+
+		names, err := parseDomainNames(tokens[:2], origin, 2)
+		if err != nil {
+			return nil, err
+		}
+
+		soa := &SOAData{MName: *names[0], RName: *names[1]}
+
+		soa.Serial, err = parseUint32(tokens[2].text)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, field := range []*uint32{&soa.Refresh, &soa.Retry, &soa.Expire, &soa.Minimum} {
+			*field, err = parseTTL(tokens[3+i].text)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return soa, nil
+	case TYPE_SRV:
+		if len(tokens) != 4 {
+			return nil, fmt.Errorf("expected a priority, weight, port and target")
+		}
+
+		srv := &SRVData{}
+		for i, field := range []*uint16{&srv.Priority, &srv.Weight, &srv.Port} {
+			value, err := parseUint16(tokens[i].text)
+			if err != nil {
+				return nil, err
+			}
+			*field = value
+		}
+
+		names, err := parseDomainNames(tokens[3:], origin, 1)
+		if err != nil {
+			return nil, err
+		}
+		srv.Target = *names[0]
+
+		return srv, nil
+	case TYPE_MD, TYPE_MF, TYPE_MB, TYPE_MG, TYPE_MR, TYPE_MINFO:
+		names, err := parseDomainNames(tokens, origin, nameListTypes[rrType])
+		if err != nil {
+			return nil, err
+		}
+
+		data := &NameListData{Names: make([]DomainName, 0, len(names))}
+		for _, name := range names {
+			data.Names = append(data.Names, *name)
+		}
+
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unsupported record type, use the generic \\# format")
+	}
+}
+
+// \# <length> <hexadecimal data>
+func parseGenericRecordData(rrType ResourceRecordType, tokens []zoneToken) (RecordData, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("expected the RDATA length")
+	}
+
+	length, err := parseUint16(tokens[0].text)
+	if err != nil {
+		return nil, err
+	}
+
+	hexData := make([]string, 0, len(tokens)-1)
+	for _, token := range tokens[1:] {
+		hexData = append(hexData, token.text)
+	}
+
+	data, err := hex.DecodeString(strings.Join(hexData, ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hexadecimal RDATA: %w", err)
+	}
+
+	if len(data) != int(length) {
+		return nil, fmt.Errorf("RDATA length is %d, but %d bytes are given", length, len(data))
+	}
+
+	// Typed RDATA is decoded from its wire format, so that it can still be
+	// compressed when written
+	if rrType != TYPE_OPT {
+		if _, ok := typeNames[rrType]; ok {
+			return deserializeRecordData(data, 0, len(data), rrType)
+		}
+	}
+
+	return &RawData{Data: data}, nil
+}
+
+func parseIP(tokens []zoneToken, v6 bool) (net.IP, error) {
+	if len(tokens) != 1 {
+		return nil, fmt.Errorf("expected a single address")
+	}
+
+	// IPv4-mapped IPv6 addresses are still written with colons
+	ip := net.ParseIP(tokens[0].text)
+	if ip == nil || strings.Contains(tokens[0].text, ":") != v6 {
+		return nil, fmt.Errorf("invalid address %q", tokens[0].text)
+	}
+
+	if !v6 {
+		return ip.To4(), nil
+	}
+
+	return ip, nil
+}
+
+func parseDomainNames(tokens []zoneToken, origin *DomainName, count int) ([]*DomainName, error) {
+	if len(tokens) != count {
+		return nil, fmt.Errorf("expected %d domain name(s), got %d tokens", count, len(tokens))
+	}
+
+	names := make([]*DomainName, 0, count)
+	for _, token := range tokens {
+		name, err := ParseDomainName(token.text, origin)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+func parseUint16(text string) (uint16, error) {
+	value, err := strconv.ParseUint(text, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid 16 bit number %q", text)
+	}
+
+	return uint16(value), nil
+}
+
+func parseUint32(text string) (uint32, error) {
+	value, err := strconv.ParseUint(text, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid 32 bit number %q", text)
+	}
+
+	return uint32(value), nil
+}
+
+var ttlUnits = map[byte]uint64{
+	's': 1,
+	'm': 60,
+	'h': 60 * 60,
+	'd': 24 * 60 * 60,
+	'w': 7 * 24 * 60 * 60,
+}
+
+// Parses a TTL, either as a number of seconds or with units like "1h30m".
+func parseTTL(text string) (uint32, error) {
+	if value, err := strconv.ParseUint(text, 10, 32); err == nil {
+		return uint32(value), nil
+	}
+
+	total := uint64(0)
+	number := uint64(0)
+	hasNumber := false
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if isDigit(c) {
+			number = number*10 + uint64(c-'0')
+			hasNumber = true
+		} else if unit, ok := ttlUnits[c|0x20]; ok && hasNumber {
+			total += number * unit
+			number = 0
+			hasNumber = false
+		} else {
+			return 0, fmt.Errorf("invalid TTL %q", text)
+		}
+
+		if total+number > 0xFFFFFFFF {
+			return 0, fmt.Errorf("TTL %q is too large", text)
+		}
+	}
+
+	if hasNumber {
+		return 0, fmt.Errorf("invalid TTL %q", text)
+	}
+
+	return uint32(total), nil
+}
+
+// Resolves the escapes of a <character-string>: \X is X itself and \DDD is
+// the octet with the decimal value DDD.
+func unescapeCharacterString(text string) (string, error) {
+	buf := make([]byte, 0, len(text))
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c != '\\' {
+			buf = append(buf, c)
+			continue
+		}
+
+		if i+3 < len(text) && isDigit(text[i+1]) && isDigit(text[i+2]) && isDigit(text[i+3]) {
+			value := int(text[i+1]-'0')*100 + int(text[i+2]-'0')*10 + int(text[i+3]-'0')
+			if value > 0xFF {
+				return "", fmt.Errorf("invalid escape in %q", text)
+			}
+
+			buf = append(buf, byte(value))
+			i += 3
+		} else if i+1 < len(text) {
+			buf = append(buf, text[i+1])
+			i += 1
+		} else {
+			return "", fmt.Errorf("incomplete escape in %q", text)
+		}
+	}
+
+	if len(buf) > 255 {
+		return "", fmt.Errorf("string length %d exceeds maximum of 255", len(buf))
+	}
+
+	return string(buf), nil
+}
+
+func (d *AData) String() string {
+	return d.IP.String()
+}
+
+func (d *AAAAData) String() string {
+	// net.IP prints IPv4-mapped addresses like IPv4 addresses
+	if ip := d.IP.To4(); ip != nil && len(d.IP) == net.IPv6len {
+		return "::ffff:" + ip.String()
+	}
+
+	return d.IP.String()
+}
+
+func (d *NSData) String() string {
+	return d.Host.String()
+}
+
+func (d *CNAMEData) String() string {
+	return d.Target.String()
+}
+
+func (d *PTRData) String() string {
+	return d.Target.String()
+}
+
+func (d *MXData) String() string {
+	return fmt.Sprintf("%d %s", d.Preference, d.Exchange.String())
+}
+
+func (d *TXTData) String() string {
+	quoted := make([]string, 0, len(d.Strings))
+	for _, str := range d.Strings {
+		quoted = append(quoted, quoteCharacterString(str))
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+func (d *SOAData) String() string {
+	return fmt.Sprintf("%s %s %d %d %d %d %d", d.MName.String(), d.RName.String(),
+		d.Serial, d.Refresh, d.Retry, d.Expire, d.Minimum)
+}
+
+func (d *SRVData) String() string {
+	return fmt.Sprintf("%d %d %d %s", d.Priority, d.Weight, d.Port, d.Target.String())
+}
+
+func (d *NameListData) String() string {
+	names := make([]string, 0, len(d.Names))
+	for i := range d.Names {
+		names = append(names, d.Names[i].String())
+	}
+
+	return strings.Join(names, " ")
+}
+
+func (d *RawData) String() string {
+	return formatGenericRecordData(d.Data)
+}
+
+func (d *OPTData) String() string {
+	w := newMessageWriter(false)
+	err := d.serializeTo(w)
+	if err != nil {
+		return fmt.Sprintf("; invalid OPT RDATA: %v", err)
+	}
+
+	return formatGenericRecordData(w.bytes())
+}
+
+// Formats RDATA in the generic format of RFC 3597 section 5.
+func formatGenericRecordData(data []byte) string {
+	if len(data) == 0 {
+		return `\# 0`
+	}
+
+	return fmt.Sprintf(`\# %d %s`, len(data), hex.EncodeToString(data))
+}
+
+// Quotes a <character-string>, escaping quotes, backslashes and
+// non-printable octets, so that unescapeCharacterString reverses it.
+func quoteCharacterString(str string) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		switch {
+		case c == '"' || c == '\\':
+			builder.WriteByte('\\')
+			builder.WriteByte(c)
+		case c < 0x20 || c >= 0x7F:
+			fmt.Fprintf(&builder, "\\%03d", c)
+		default:
+			builder.WriteByte(c)
+		}
+	}
+
+	builder.WriteByte('"')
+	return builder.String()
+}
@@ -0,0 +1,500 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// The IPv4 addresses of the root servers a.root-servers.net through
+// m.root-servers.net, where iterative resolution starts by default.
+var DefaultRootHints = []string{
+	"198.41.0.4",
+	"170.247.170.2",
+	"192.33.4.12",
+	"199.7.91.13",
+	"192.203.230.10",
+	"192.5.5.241",
+	"192.112.36.4",
+	"198.97.190.53",
+	"192.36.148.17",
+	"192.58.128.30",
+	"193.0.14.129",
+	"199.7.83.42",
+	"202.12.27.33",
+}
+
+// How long to wait for a name server to answer a query.
+const RECURSIVE_TIMEOUT = 2 * time.Second
+
+// How deeply resolutions may nest, e.g. to find the address of a name server
+// for which the referral carried no glue.
+const MAX_RECURSION_DEPTH = 6
+
+// The most queries sent to name servers while resolving a single question,
+// including those of nested resolutions.
+const MAX_RECURSION_QUERIES = 100
+
+// The number of RRsets kept in the delegation cache.
+const DELEGATION_CACHE_SIZE = 10000
+
+type RecursiveOptions struct {
+	// Addresses of the root servers, with an optional port. Defaults to
+	// DefaultRootHints.
+	RootHints []string
+	// The port name servers learned from referrals are queried on. Defaults
+	// to 53.
+	Port int
+	// How long to wait for a name server to answer. Defaults to
+	// RECURSIVE_TIMEOUT.
+	Timeout time.Duration
+}
+
+// Resolves questions iteratively, the way a recursive resolver does: starting
+// from the root servers, it follows referrals down to the name servers
+// authoritative for the name, and chases CNAMEs across zones (RFC 1034
+// section 5.3.3). The zone cuts and name server addresses learned from
+// referrals are cached, so later questions skip the servers above them.
+type RecursiveResolver struct {
+	rootServers []string
+	port        string
+	timeout     time.Duration
+	// NS RRsets of zone cuts and the addresses of their name servers.
+	delegations *rrSetCache
+}
+
+// Counts the work done for a single question, to bound it.
+type recursionState struct {
+	queries int
+}
+
+func InitRecursiveResolver(options RecursiveOptions) (*RecursiveResolver, error) {
+	port := options.Port
+	if port == 0 {
+		port = 53
+	}
+
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = RECURSIVE_TIMEOUT
+	}
+
+	rootHints := options.RootHints
+	if len(rootHints) == 0 {
+		rootHints = DefaultRootHints
+	}
+
+	rootServers := make([]string, 0, len(rootHints))
+	for _, hint := range rootHints {
+		if _, _, err := net.SplitHostPort(hint); err == nil {
+			rootServers = append(rootServers, hint)
+			continue
+		}
+
+		if net.ParseIP(hint) == nil {
+			return nil, fmt.Errorf("root hint %q is not an IP address", hint)
+		}
+
+		rootServers = append(rootServers, net.JoinHostPort(hint, strconv.Itoa(port)))
+	}
+
+	return &RecursiveResolver{
+		rootServers: rootServers,
+		port:        strconv.Itoa(port),
+		timeout:     timeout,
+		delegations: newRRSetCache(DELEGATION_CACHE_SIZE),
+	}, nil
+}
+
+func (r *RecursiveResolver) Resolve(request *Message) *Message {
+	return resolveWithoutContext(r, request)
+}
+
+func (r *RecursiveResolver) ResolveContext(ctx context.Context, info *RequestInfo, request *Message) (*Message, error) {
+	answers := make([]ResourceRecord, 0)
+	authority := make([]ResourceRecord, 0)
+	isValidRequest := request.Header.Flags.OPCODE == 0
+	returnCode := RCodeNoError
+	if !isValidRequest {
+		returnCode = RCodeNotImplemented
+	}
+
+	if isValidRequest {
+		for _, question := range request.Questions {
+			answer, err := r.resolveName(ctx, &question, 0, &recursionState{})
+			if err != nil {
+				return nil, fmt.Errorf("resolving %s %s: %w", question.Name.String(), question.Type, err)
+			}
+
+			answers = append(answers, answer.Answers...)
+			authority = append(authority, answer.Authority...)
+			if returnCode == RCodeNoError {
+				returnCode = answer.Header.Flags.RCODE
+			}
+		}
+	}
+
+	return &Message{
+		Header: Header{
+			ID: request.Header.ID,
+			Flags: Flags{
+				QR:     true,
+				OPCODE: request.Header.Flags.OPCODE,
+				AA:     false,
+				TC:     false,
+				RD:     request.Header.Flags.RD,
+				RA:     true,
+				Z:      0,
+				RCODE:  returnCode,
+			},
+			QDCOUNT: uint16(len(request.Questions)),
+			ANCOUNT: uint16(len(answers)),
+			NSCOUNT: uint16(len(authority)),
+			ARCOUNT: 0,
+		},
+		Questions: request.Questions,
+		Answers:   answers,
+		Authority: authority,
+	}, nil
+}
+
+// Resolves the question, chasing CNAMEs which lead out of the zone of the
+// server that answered them. The answers of all servers involved are
+// combined, together with the authority section of the last one.
+func (r *RecursiveResolver) resolveName(ctx context.Context, question *Question, depth int, state *recursionState) (*Message, error) {
+	answers := make([]ResourceRecord, 0)
+	name := question.Name
+	visited := make(map[string]bool)
+
+	for i := 0; i <= MAX_CNAME_CHAIN; i++ {
+		if visited[nameKey(name.Labels)] {
+			return nil, fmt.Errorf("CNAME loop at %s", name.String())
+		}
+		visited[nameKey(name.Labels)] = true
+
+		current := Question{Name: name, Type: question.Type, Class: question.Class}
+		response, err := r.resolveIteratively(ctx, &current, depth, state)
+		if err != nil {
+			return nil, err
+		}
+
+		answers = append(answers, response.Answers...)
+		response.Answers = answers
+
+		if response.Header.Flags.RCODE != RCodeNoError || question.Type == TYPE_CNAME {
+			return response, nil
+		}
+
+		// The server may have followed the chain within its own zone already
+		end := followCNAMEs(response.Answers, &name, question.Class)
+		if hasRRSet(response.Answers, end, question.Type, question.Class) || end.Equal(&name) {
+			return response, nil
+		}
+
+		name = *end
+	}
+
+	return nil, fmt.Errorf("CNAME chain of %s is too long", question.Name.String())
+}
+
+// Asks the name servers for the question, starting with the closest known
+// ones and following referrals until a server answers authoritatively.
+func (r *RecursiveResolver) resolveIteratively(ctx context.Context, question *Question, depth int, state *recursionState) (*Message, error) {
+	zone, servers := r.closestServers(&question.Name)
+
+	for {
+		response, err := r.queryServers(ctx, servers, question, state)
+		if err != nil {
+			return nil, fmt.Errorf("querying servers of %s: %w", zone.String(), err)
+		}
+
+		response.Answers = filterAnswers(response.Answers, question, zone)
+
+		if response.Header.Flags.RCODE != RCodeNoError || len(response.Answers) > 0 || response.Header.Flags.AA {
+			return response, nil
+		}
+
+		cut, nsRecords := findReferral(response, zone, &question.Name)
+		if cut == nil {
+			// Neither an answer nor a referral, the best there is
+			return response, nil
+		}
+
+		r.cacheDelegation(nsRecords, response.Additional, zone)
+
+		servers, err = r.nameServerAddresses(ctx, nsRecords, depth, state)
+		if err != nil {
+			return nil, fmt.Errorf("finding servers of %s: %w", cut.String(), err)
+		}
+
+		zone = cut
+	}
+}
+
+// Returns the closest zone cut above the name whose name server addresses
+// are cached, or the root and its servers if there is none.
+func (r *RecursiveResolver) closestServers(name *DomainName) (*DomainName, []string) {
+	now := time.Now()
+
+	for zone := *name; len(zone.Labels) > 0; zone = zone.Parent() {
+		nsRecords, ok := r.delegations.get(makeRRSetKey(&zone, TYPE_NS, CLASS_IN), now)
+		if !ok {
+			continue
+		}
+
+		servers := r.cachedAddresses(nsRecords, now)
+		if len(servers) > 0 {
+			return &zone, servers
+		}
+	}
+
+	return &DomainName{Labels: make([]Label, 0)}, r.rootServers
+}
+
+// Sends the question to the servers in turn, until one of them answers.
+// Servers which fail or refuse to answer are skipped.
+func (r *RecursiveResolver) queryServers(ctx context.Context, servers []string, question *Question, state *recursionState) (*Message, error) {
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no name server addresses")
+	}
+
+	var lastErr error
+	for _, server := range servers {
+		if state.queries >= MAX_RECURSION_QUERIES {
+			return nil, fmt.Errorf("gave up after %d queries", state.queries)
+		}
+		state.queries++
+
+		// Nobody is waiting for the answer anymore
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		id, err := randomID()
+		if err != nil {
+			return nil, err
+		}
+
+		request := questionToMessage(id, question)
+		request.Header.Flags.RD = false
+
+		response, err := Exchange(ctx, request, server, r.timeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if isUpstreamFailure(response) {
+			lastErr = fmt.Errorf("%s answered with RCODE %d", server, response.Header.Flags.RCODE)
+			continue
+		}
+
+		response.SetEDNS(nil)
+		return response, nil
+	}
+
+	return nil, lastErr
+}
+
+// Keeps the records of an answer which answer the question and which the
+// server is authoritative for: the CNAME chain starting at the name and the
+// RRset it leads to, as far as they lie within the zone of the server. Any
+// other record could have been inserted by a server on the path, to poison
+// the cache. The rest of a chain leading out of the zone is asked for from
+// the servers of the zones it leads to.
+func filterAnswers(records []ResourceRecord, question *Question, zone *DomainName) []ResourceRecord {
+	inZone := make([]ResourceRecord, 0, len(records))
+	for _, record := range records {
+		if record.Class == question.Class && record.Name.IsSubdomainOf(zone) {
+			inZone = append(inZone, record)
+		}
+	}
+
+	chain := []*DomainName{&question.Name}
+	if question.Type != TYPE_CNAME {
+		chain = cnameChain(inZone, &question.Name, question.Class)
+	}
+
+	owners := make(map[string]bool)
+	for _, name := range chain {
+		owners[nameKey(name.Labels)] = true
+	}
+
+	filtered := make([]ResourceRecord, 0, len(inZone))
+	for _, record := range inZone {
+		if owners[nameKey(record.Name.Labels)] && (record.Type == question.Type || record.Type == TYPE_CNAME) {
+			filtered = append(filtered, record)
+		}
+	}
+
+	return filtered
+}
+
+// Returns the zone cut and NS records of a referral: NS records for a zone
+// between the zone of the server and the name. Referrals to zones outside
+// of that range are ignored, which also ensures that every referral gets
+// closer to the name.
+func findReferral(response *Message, zone *DomainName, name *DomainName) (*DomainName, []ResourceRecord) {
+	var cut *DomainName
+	nsRecords := make([]ResourceRecord, 0)
+
+	for i, record := range response.Authority {
+		if record.Type != TYPE_NS || record.Class != CLASS_IN {
+			continue
+		}
+
+		owner := &response.Authority[i].Name
+		if cut == nil {
+			if len(owner.Labels) <= len(zone.Labels) || !owner.IsSubdomainOf(zone) || !name.IsSubdomainOf(owner) {
+				continue
+			}
+			cut = owner
+		}
+
+		if owner.Equal(cut) {
+			nsRecords = append(nsRecords, record)
+		}
+	}
+
+	return cut, nsRecords
+}
+
+// Caches the NS records of a referral together with their glue. Glue is only
+// trusted if it is within the zone of the server that sent it.
+func (r *RecursiveResolver) cacheDelegation(nsRecords []ResourceRecord, additional []ResourceRecord, zone *DomainName) {
+	now := time.Now()
+	r.cacheRRSet(nsRecords, now)
+
+	for _, rrSet := range groupRRSets(additional) {
+		first := &rrSet[0]
+		if first.Type != TYPE_A && first.Type != TYPE_AAAA || !first.Name.IsSubdomainOf(zone) {
+			continue
+		}
+
+		for _, ns := range nsRecords {
+			if host, ok := ns.RData.(*NSData); ok && host.Host.Equal(&first.Name) {
+				r.cacheRRSet(rrSet, now)
+				break
+			}
+		}
+	}
+}
+
+func (r *RecursiveResolver) cacheRRSet(rrSet []ResourceRecord, now time.Time) {
+	if len(rrSet) == 0 {
+		return
+	}
+
+	ttl := rrSet[0].TTL
+	for _, record := range rrSet[1:] {
+		if record.TTL < ttl {
+			ttl = record.TTL
+		}
+	}
+
+	if ttl == 0 {
+		return
+	}
+
+	first := &rrSet[0]
+	r.delegations.put(makeRRSetKey(&first.Name, first.Type, first.Class), rrSet, time.Duration(ttl)*time.Second, now)
+}
+
+// Returns the cached addresses of the name servers, IPv4 first.
+func (r *RecursiveResolver) cachedAddresses(nsRecords []ResourceRecord, now time.Time) []string {
+	servers := make([]string, 0)
+
+	for _, rrType := range []ResourceRecordType{TYPE_A, TYPE_AAAA} {
+		for _, ns := range nsRecords {
+			host, ok := ns.RData.(*NSData)
+			if !ok {
+				continue
+			}
+
+			addresses, ok := r.delegations.get(makeRRSetKey(&host.Host, rrType, CLASS_IN), now)
+			if !ok {
+				continue
+			}
+
+			for _, address := range addresses {
+				servers = append(servers, r.serverAddress(&address))
+			}
+		}
+	}
+
+	return servers
+}
+
+// Returns the addresses of the name servers. If no referral carried glue for
+// any of them, the addresses of the name servers are resolved first.
+func (r *RecursiveResolver) nameServerAddresses(ctx context.Context, nsRecords []ResourceRecord, depth int, state *recursionState) ([]string, error) {
+	servers := r.cachedAddresses(nsRecords, time.Now())
+	if len(servers) > 0 {
+		return servers, nil
+	}
+
+	if depth >= MAX_RECURSION_DEPTH {
+		return nil, fmt.Errorf("name server resolution nested more than %d levels deep", MAX_RECURSION_DEPTH)
+	}
+
+	var lastErr error
+	for _, ns := range nsRecords {
+		host, ok := ns.RData.(*NSData)
+		if !ok {
+			continue
+		}
+
+		question := Question{Name: host.Host, Type: TYPE_A, Class: CLASS_IN}
+		response, err := r.resolveName(ctx, &question, depth+1, state)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		addresses := make([]ResourceRecord, 0)
+		for _, record := range response.Answers {
+			if record.Type == TYPE_A && record.Name.Equal(followCNAMEs(response.Answers, &host.Host, CLASS_IN)) {
+				addresses = append(addresses, record)
+			}
+		}
+
+		r.cacheRRSet(addresses, time.Now())
+		for _, address := range addresses {
+			servers = append(servers, r.serverAddress(&address))
+		}
+
+		if len(servers) > 0 {
+			return servers, nil
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("name servers have no addresses")
+	}
+
+	return nil, lastErr
+}
+
+func (r *RecursiveResolver) serverAddress(record *ResourceRecord) string {
+	switch data := record.RData.(type) {
+	case *AData:
+		return net.JoinHostPort(data.IP.String(), r.port)
+	case *AAAAData:
+		return net.JoinHostPort(data.IP.String(), r.port)
+	}
+
+	return ""
+}
+
+// Whether the records include an RRset of the type owned by the name.
+func hasRRSet(records []ResourceRecord, name *DomainName, rrType ResourceRecordType, rrClass ResourceRecordClass) bool {
+	for _, record := range records {
+		if record.Type == rrType && record.Class == rrClass && record.Name.Equal(name) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,313 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The zones of the test hierarchy, by the loopback address of the server
+// authoritative for them. All servers listen on the same port, as name
+// servers learned from referrals are queried on a fixed port.
+var testHierarchy = map[string][][]string{
+	// The root zone, delegating com. and net. with glue
+	"127.0.0.1": {{
+		". 86400 IN SOA ns.root. hostmaster.root. 1 7200 3600 1209600 300",
+		". 86400 IN NS ns.root.",
+		"ns.root. 86400 IN A 127.0.0.1",
+		"com. 86400 IN NS ns.tld.com.",
+		"ns.tld.com. 86400 IN A 127.0.0.2",
+		"net. 86400 IN NS ns.tld.net.",
+		"ns.tld.net. 86400 IN A 127.0.0.2",
+	}},
+	"127.0.0.2": {
+		{
+			"com. 86400 IN SOA ns.tld.com. hostmaster.com. 1 7200 3600 1209600 300",
+			"com. 86400 IN NS ns.tld.com.",
+			"ns.tld.com. 86400 IN A 127.0.0.2",
+			"example.com. 86400 IN NS ns1.example.com.",
+			"ns1.example.com. 86400 IN A 127.0.0.3",
+			// Served by a name server in another TLD, so there is no glue
+			"noglue.com. 86400 IN NS ns.provider.net.",
+			// Each is served by a name server in the other, without glue
+			"loopa.com. 86400 IN NS ns.loopb.com.",
+			"loopb.com. 86400 IN NS ns.loopa.com.",
+		},
+		{
+			"net. 86400 IN SOA ns.tld.net. hostmaster.net. 1 7200 3600 1209600 300",
+			"net. 86400 IN NS ns.tld.net.",
+			"ns.tld.net. 86400 IN A 127.0.0.2",
+			"provider.net. 86400 IN NS ns.provider.net.",
+			"ns.provider.net. 86400 IN A 127.0.0.3",
+		},
+	},
+	"127.0.0.3": {
+		{
+			"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+			"example.com. 3600 IN NS ns1.example.com.",
+			"ns1.example.com. 3600 IN A 127.0.0.3",
+			"www.example.com. 300 IN A 192.0.2.1",
+			"alias.example.com. 300 IN CNAME www.noglue.com.",
+			"loop.example.com. 300 IN CNAME loop.noglue.com.",
+		},
+		{
+			"noglue.com. 3600 IN SOA ns.provider.net. hostmaster.noglue.com. 1 7200 3600 1209600 300",
+			"noglue.com. 3600 IN NS ns.provider.net.",
+			"www.noglue.com. 300 IN A 192.0.2.2",
+			"loop.noglue.com. 300 IN CNAME loop.example.com.",
+		},
+		{
+			"provider.net. 3600 IN SOA ns.provider.net. hostmaster.provider.net. 1 7200 3600 1209600 300",
+			"provider.net. 3600 IN NS ns.provider.net.",
+			"ns.provider.net. 3600 IN A 127.0.0.3",
+		},
+	},
+}
+
+// An authoritative name server running in the test process.
+type testNameServer struct {
+	conn     *net.UDPConn
+	resolver *AuthoritativeResolver
+	queries  atomic.Int32
+	// Changes the response to a request before it is sent, if set.
+	tamper func(request *Message, response *Message)
+}
+
+func (s *testNameServer) serve() {
+	buf := make([]byte, MAX_TCP_MESSAGE_SIZE)
+	for {
+		size, source, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		request, err := DeserializeMessage(buf[:size])
+		if err != nil {
+			continue
+		}
+		s.queries.Add(1)
+
+		response := s.resolver.Resolve(request)
+		if s.tamper != nil {
+			s.tamper(request, response)
+		}
+
+		response.Header.ANCOUNT = uint16(len(response.Answers))
+		response.Header.NSCOUNT = uint16(len(response.Authority))
+		response.Header.ARCOUNT = uint16(len(response.Additional))
+
+		serialized, err := response.Serialize()
+		if err != nil {
+			continue
+		}
+
+		s.conn.WriteToUDP(serialized, source)
+	}
+}
+
+// Starts the servers of testHierarchy on a free port. Returns them by
+// address, and the port.
+func startTestHierarchy(t *testing.T) (map[string]*testNameServer, int) {
+	t.Helper()
+
+	zones := make(map[string][]*Zone)
+	for address, zoneRecords := range testHierarchy {
+		for _, records := range zoneRecords {
+			zone, err := NewZone(parseRecords(t, records...))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			zones[address] = append(zones[address], zone)
+		}
+	}
+
+	// The port is free on 127.0.0.1, and most likely on the other
+	// addresses as well
+	for attempt := 0; attempt < 10; attempt++ {
+		servers, port, err := listenTestHierarchy(zones)
+		if err != nil {
+			continue
+		}
+
+		t.Cleanup(func() {
+			for _, server := range servers {
+				server.conn.Close()
+			}
+		})
+
+		for _, server := range servers {
+			go server.serve()
+		}
+
+		return servers, port
+	}
+
+	t.Fatal("no port free on every address of the hierarchy")
+	return nil, 0
+}
+
+func listenTestHierarchy(zones map[string][]*Zone) (map[string]*testNameServer, int, error) {
+	servers := make(map[string]*testNameServer)
+	port := 0
+
+	for _, address := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"} {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(address), Port: port})
+		if err != nil {
+			for _, server := range servers {
+				server.conn.Close()
+			}
+			return nil, 0, err
+		}
+
+		port = conn.LocalAddr().(*net.UDPAddr).Port
+
+		resolver, err := InitAuthoritativeResolver(zones[address], nil)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		servers[address] = &testNameServer{conn: conn, resolver: resolver}
+	}
+
+	return servers, port, nil
+}
+
+func newTestRecursiveResolver(t *testing.T, port int) *RecursiveResolver {
+	t.Helper()
+
+	resolver, err := InitRecursiveResolver(RecursiveOptions{
+		RootHints: []string{"127.0.0.1"},
+		Port:      port,
+		Timeout:   time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resolver
+}
+
+func resolveRecursively(resolver *RecursiveResolver, name string, rrType ResourceRecordType) (*Message, error) {
+	qname, err := ParseDomainName(name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &Message{
+		Header:    Header{ID: 1, QDCOUNT: 1, Flags: Flags{RD: true}},
+		Questions: []Question{{Name: *qname, Type: rrType, Class: CLASS_IN}},
+	}
+
+	return resolver.ResolveContext(context.Background(), &RequestInfo{}, request)
+}
+
+func expectAnswers(t *testing.T, resolver *RecursiveResolver, name string, expected ...string) {
+	t.Helper()
+
+	response, err := resolveRecursively(resolver, name, TYPE_A)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Header.Flags.RCODE != RCodeNoError {
+		t.Errorf("RCODE is %v", response.Header.Flags.RCODE)
+	}
+
+	if answers := recordStrings(response.Answers); !reflect.DeepEqual(answers, expected) {
+		t.Errorf("answers are %q, expected %q", answers, expected)
+	}
+}
+
+func TestRecursiveResolverFollowsReferrals(t *testing.T) {
+	servers, port := startTestHierarchy(t)
+	resolver := newTestRecursiveResolver(t, port)
+
+	expectAnswers(t, resolver, "www.example.com.", "www.example.com. 300 IN A 192.0.2.1")
+
+	for address, server := range servers {
+		if server.queries.Load() != 1 {
+			t.Errorf("%s was queried %d times, expected once", address, server.queries.Load())
+		}
+	}
+
+	// The delegation to example.com. is cached, so only its server is asked
+	expectAnswers(t, resolver, "www.example.com.", "www.example.com. 300 IN A 192.0.2.1")
+	if servers["127.0.0.1"].queries.Load() != 1 || servers["127.0.0.3"].queries.Load() != 2 {
+		t.Errorf("queries didn't start at the cached delegation")
+	}
+
+	response, err := resolveRecursively(resolver, "missing.example.com.", TYPE_A)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Header.Flags.RCODE != RCodeNameError || len(response.Authority) != 1 || response.Authority[0].Type != TYPE_SOA {
+		t.Errorf("expected NXDOMAIN with the SOA, got %v %v", response.Header.Flags.RCODE, response.Authority)
+	}
+}
+
+func TestRecursiveResolverResolvesNameServersWithoutGlue(t *testing.T) {
+	_, port := startTestHierarchy(t)
+	resolver := newTestRecursiveResolver(t, port)
+
+	expectAnswers(t, resolver, "www.noglue.com.", "www.noglue.com. 300 IN A 192.0.2.2")
+}
+
+func TestRecursiveResolverChasesCNAMEsAcrossZones(t *testing.T) {
+	_, port := startTestHierarchy(t)
+	resolver := newTestRecursiveResolver(t, port)
+
+	expectAnswers(t, resolver, "alias.example.com.",
+		"alias.example.com. 300 IN CNAME www.noglue.com.",
+		"www.noglue.com. 300 IN A 192.0.2.2")
+}
+
+func TestRecursiveResolverDropsRecordsOutsideOfTheServersZone(t *testing.T) {
+	servers, port := startTestHierarchy(t)
+	resolver := newTestRecursiveResolver(t, port)
+
+	// The server of example.com. makes up the target of the CNAME, and
+	// records unrelated to the question
+	servers["127.0.0.3"].tamper = func(request *Message, response *Message) {
+		if request.Questions[0].Name.String() != "alias.example.com." {
+			return
+		}
+
+		response.Answers = append(response.Answers, parseRecords(t,
+			"www.noglue.com. 300 IN A 198.51.100.1",
+			"other.example.com. 300 IN A 198.51.100.2",
+			"victim.org. 300 IN A 198.51.100.3")...)
+	}
+
+	expectAnswers(t, resolver, "alias.example.com.",
+		"alias.example.com. 300 IN CNAME www.noglue.com.",
+		"www.noglue.com. 300 IN A 192.0.2.2")
+}
+
+func TestRecursiveResolverStopsAtLimits(t *testing.T) {
+	_, port := startTestHierarchy(t)
+	resolver := newTestRecursiveResolver(t, port)
+
+	tests := []struct {
+		name string
+		err  string
+	}{
+		{"loop.example.com.", "CNAME loop"},
+		// Finding the servers of either zone requires those of the other
+		{"www.loopa.com.", fmt.Sprintf("nested more than %d levels deep", MAX_RECURSION_DEPTH)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := resolveRecursively(resolver, test.name, TYPE_A)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("error is %v, expected %q", err, test.err)
+			}
+		})
+	}
+}
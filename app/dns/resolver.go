@@ -1,5 +1,122 @@
 package dns
 
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// The longest CNAME chain a resolver follows before giving up, which guards
+// against CNAME loops.
+const MAX_CNAME_CHAIN = 8
+
 type DnsResolver interface {
 	Resolve(msg *Message) *Message
 }
+
+// A resolver which knows who is asking and for how long they are willing to
+// wait. Resolvers give up once ctx is done, and return an error instead of a
+// response if they can't answer at all, which the server turns into a
+// SERVFAIL.
+type ContextResolver interface {
+	ResolveContext(ctx context.Context, info *RequestInfo, msg *Message) (*Message, error)
+}
+
+// The transport a request was received over.
+type Transport int
+
+const (
+	TransportUDP Transport = iota
+	TransportTCP
+)
+
+func (t Transport) String() string {
+	switch t {
+	case TransportUDP:
+		return "udp"
+	case TransportTCP:
+		return "tcp"
+	}
+
+	return fmt.Sprintf("Transport(%d)", int(t))
+}
+
+// What is known about a request besides the message itself.
+type RequestInfo struct {
+	// The address the request was received from.
+	ClientAddr net.Addr
+	// The address the request was received on.
+	ServerAddr net.Addr
+	Transport  Transport
+	// The largest response the client is able to receive. Over UDP this is
+	// the payload size advertised via EDNS, or 512 without it.
+	MaxResponseSize int
+	// When the request was received.
+	ReceivedAt time.Time
+
+	// Filled in by the resolvers while the request is resolved, for the
+	// query log. Whether the response was answered from the cache.
+	CacheHit bool
+	// The address of the upstream the request was forwarded to, empty if it
+	// wasn't forwarded.
+	Upstream string
+}
+
+// Returns the IP address of the client, or nil if it is unknown.
+func (i *RequestInfo) ClientIP() net.IP {
+	switch addr := i.ClientAddr.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	}
+
+	return nil
+}
+
+// Returns the resolver as a ContextResolver. Resolvers which only implement
+// DnsResolver are wrapped: they still run to completion, but the caller stops
+// waiting for them once ctx is done.
+func AdaptResolver(resolver DnsResolver) ContextResolver {
+	if contextResolver, ok := resolver.(ContextResolver); ok {
+		return contextResolver
+	}
+
+	return &resolverAdapter{resolver: resolver}
+}
+
+type resolverAdapter struct {
+	resolver DnsResolver
+}
+
+func (a *resolverAdapter) ResolveContext(ctx context.Context, info *RequestInfo, msg *Message) (*Message, error) {
+	// Buffered, so that the resolver can finish after the caller gave up
+	responses := make(chan *Message, 1)
+	go func() {
+		responses <- a.resolver.Resolve(msg)
+	}()
+
+	select {
+	case response := <-responses:
+		return response, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (a *resolverAdapter) Resolve(msg *Message) *Message {
+	return a.resolver.Resolve(msg)
+}
+
+// Implements DnsResolver in terms of ContextResolver, for requests without a
+// context. Errors are answered with SERVFAIL.
+func resolveWithoutContext(resolver ContextResolver, msg *Message) *Message {
+	response, err := resolver.ResolveContext(context.Background(), &RequestInfo{}, msg)
+	if err != nil {
+		fmt.Println("Failed to resolve request:", err)
+		return MakeErrorResponse(msg, RCodeServerFailure)
+	}
+
+	return response
+}
@@ -1,5 +1,11 @@
 package dns
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 type ResourceRecordType uint16
 
 // https://www.rfc-editor.org/rfc/rfc1035#section-3.2.2
@@ -20,8 +26,60 @@ const (
 	TYPE_MINFO                    = 14 // mailbox or mail list information
 	TYPE_MX                       = 15 // mail exchange
 	TYPE_TXT                      = 16 // text strings
+	TYPE_AAAA                     = 28 // an IPv6 host address (RFC 3596)
+	TYPE_SRV                      = 33 // a service location (RFC 2782)
+	TYPE_OPT                      = 41 // an EDNS pseudo-record (RFC 6891)
 )
 
+// Mnemonics of the record types, as used in master files.
+var typeNames = map[ResourceRecordType]string{
+	TYPE_A:     "A",
+	TYPE_NS:    "NS",
+	TYPE_MD:    "MD",
+	TYPE_MF:    "MF",
+	TYPE_CNAME: "CNAME",
+	TYPE_SOA:   "SOA",
+	TYPE_MB:    "MB",
+	TYPE_MG:    "MG",
+	TYPE_MR:    "MR",
+	TYPE_NULL:  "NULL",
+	TYPE_WKS:   "WKS",
+	TYPE_PTR:   "PTR",
+	TYPE_HINFO: "HINFO",
+	TYPE_MINFO: "MINFO",
+	TYPE_MX:    "MX",
+	TYPE_TXT:   "TXT",
+	TYPE_AAAA:  "AAAA",
+	TYPE_SRV:   "SRV",
+	TYPE_OPT:   "OPT",
+}
+
+// Returns the mnemonic of the type, or TYPE<n> for types without one (RFC
+// 3597 section 5).
+func (t ResourceRecordType) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("TYPE%d", uint16(t))
+}
+
+// Parses a type mnemonic like "MX", or the generic form "TYPE15".
+func ParseResourceRecordType(text string) (ResourceRecordType, error) {
+	for rrType, name := range typeNames {
+		if strings.EqualFold(text, name) {
+			return rrType, nil
+		}
+	}
+
+	value, ok := parseGenericMnemonic(text, "TYPE")
+	if !ok {
+		return 0, fmt.Errorf("unknown record type %q", text)
+	}
+
+	return ResourceRecordType(value), nil
+}
+
 type ResourceRecordClass uint16
 
 // https://www.rfc-editor.org/rfc/rfc1035#section-3.2.4
@@ -32,6 +90,59 @@ const (
 	CLASS_HS                     = 4 // Hesiod [Dyer 87]
 )
 
+// Mnemonics of the classes, as used in master files.
+var classNames = map[ResourceRecordClass]string{
+	CLASS_IN: "IN",
+	CLASS_CS: "CS",
+	CLASS_CH: "CH",
+	CLASS_HS: "HS",
+}
+
+// Returns the mnemonic of the class, or CLASS<n> for classes without one
+// (RFC 3597 section 5).
+func (c ResourceRecordClass) String() string {
+	if name, ok := classNames[c]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("CLASS%d", uint16(c))
+}
+
+// Parses a class mnemonic like "IN", or the generic form "CLASS1".
+func ParseResourceRecordClass(text string) (ResourceRecordClass, error) {
+	for rrClass, name := range classNames {
+		if strings.EqualFold(text, name) {
+			return rrClass, nil
+		}
+	}
+
+	value, ok := parseGenericMnemonic(text, "CLASS")
+	if !ok {
+		return 0, fmt.Errorf("unknown record class %q", text)
+	}
+
+	return ResourceRecordClass(value), nil
+}
+
+// Parses the generic form of a type or class mnemonic, the prefix followed
+// by the decimal value (RFC 3597 section 5).
+func parseGenericMnemonic(text string, prefix string) (uint16, bool) {
+	if len(text) <= len(prefix) || !strings.EqualFold(text[:len(prefix)], prefix) {
+		return 0, false
+	}
+
+	value, err := strconv.ParseUint(text[len(prefix):], 10, 16)
+	if err != nil {
+		return 0, false
+	}
+
+	return uint16(value), true
+}
+
+// The smallest resource record on the wire: the root name, TYPE, CLASS, TTL,
+// RDLENGTH and no RDATA.
+const MIN_RESOURCE_RECORD_SIZE = 1 + 2 + 2 + 4 + 2
+
 //		                              1  1  1  1  1  1
 //		0  1  2  3  4  5  6  7  8  9  0  1  2  3  4  5
 //	 +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
@@ -71,30 +182,42 @@ type ResourceRecord struct {
 	// format of this information varies according to the TYPE and CLASS
 	// of the resource record. For example, the if the TYPE is A and the
 	// CLASS is IN, the RDATA field is a 4 octet ARPA Internet address.
-	RData []byte
+	// Record types without a typed model are kept as *RawData.
+	RData RecordData
 }
 
 func (r *ResourceRecord) Serialize() ([]byte, error) {
-	buf := make([]byte, 0)
+	w := newMessageWriter(false)
 
-	domainNameSerialized, err := r.Name.Serialize()
+	err := r.serializeTo(w)
 	if err != nil {
 		return nil, err
 	}
 
-	typeSerialized := uint16ToBytes(uint16(r.Type))
-	classSerialized := uint16ToBytes(uint16(r.Class))
-	ttlSerialized := uint32ToBytes(r.TTL)
-	rdLengthSerialized := uint16ToBytes(uint16(len(r.RData)))
+	return w.bytes(), nil
+}
+
+func (r *ResourceRecord) serializeTo(w *messageWriter) error {
+	err := w.writeDomainName(&r.Name)
+	if err != nil {
+		return err
+	}
+
+	w.writeUint16(uint16(r.Type))
+	w.writeUint16(uint16(r.Class))
+	w.writeUint32(r.TTL)
+
+	rdLengthOffset := w.beginLength()
+	if r.RData == nil {
+		return fmt.Errorf("resource record of type %d has no RDATA", r.Type)
+	}
 
-	buf = append(buf, domainNameSerialized...)
-	buf = append(buf, typeSerialized...)
-	buf = append(buf, classSerialized...)
-	buf = append(buf, ttlSerialized...)
-	buf = append(buf, rdLengthSerialized...)
-	buf = append(buf, r.RData...)
+	err = r.RData.serializeTo(w)
+	if err != nil {
+		return err
+	}
 
-	return buf, nil
+	return w.endLength(rdLengthOffset)
 }
 
 func deserializeResourceRecord(buf []byte, offset int) (int, *ResourceRecord, error) {
@@ -127,7 +250,10 @@ func deserializeResourceRecord(buf []byte, offset int) (int, *ResourceRecord, er
 	}
 	bytesRead += rdLengthBytesRead
 
-	rData := buf[offset+bytesRead : offset+bytesRead+int(rdLength)]
+	rData, err := deserializeRecordData(buf, offset+bytesRead, int(rdLength), rrType)
+	if err != nil {
+		return 0, nil, err
+	}
 	bytesRead += int(rdLength)
 
 	return bytesRead, &ResourceRecord{
@@ -139,42 +265,83 @@ func deserializeResourceRecord(buf []byte, offset int) (int, *ResourceRecord, er
 	}, nil
 }
 
+// Serializes a slice of resource records into the message.
+func serializeResourceRecords(w *messageWriter, records []ResourceRecord) error {
+	for _, record := range records {
+		err := record.serializeTo(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func deserializeResourceRecords(buf []byte, offset int, count uint16) (int, []ResourceRecord, error) {
+	startOffset := offset
+	records := make([]ResourceRecord, 0)
+
+	for i := uint16(0); i < count; i++ {
+		bytesRead, record, err := deserializeResourceRecord(buf, offset)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		offset += bytesRead
+		records = append(records, *record)
+	}
+
+	return offset - startOffset, records, nil
+}
+
 func deserializeType(buf []byte, offset int) (int, ResourceRecordType, error) {
-	maybeType, err := uint16FromBytes(buf[offset : offset+2])
+	if len(buf) < offset+2 {
+		return 0, 0, fmt.Errorf("not enough bytes to read TYPE")
+	}
+
+	value, err := uint16FromBytes(buf[offset : offset+2])
 	if err != nil {
 		return 0, 0, err
 	}
-	rrType := ResourceRecordType(maybeType)
 
-	return 2, rrType, nil
+	return 2, ResourceRecordType(value), nil
 }
 
 func deserializeClass(buf []byte, offset int) (int, ResourceRecordClass, error) {
-	maybeClass, err := uint16FromBytes(buf[offset : offset+2])
+	if len(buf) < offset+2 {
+		return 0, 0, fmt.Errorf("not enough bytes to read CLASS")
+	}
+
+	value, err := uint16FromBytes(buf[offset : offset+2])
 	if err != nil {
 		return 0, 0, err
 	}
-	rrClass := ResourceRecordClass(maybeClass)
 
-	return 2, rrClass, nil
+	return 2, ResourceRecordClass(value), nil
 }
 
 func deserializeTtl(buf []byte, offset int) (int, uint32, error) {
-	maybeTtl, err := uint32FromBytes(buf[offset : offset+4])
+	if len(buf) < offset+4 {
+		return 0, 0, fmt.Errorf("not enough bytes to read TTL")
+	}
+
+	value, err := uint32FromBytes(buf[offset : offset+4])
 	if err != nil {
 		return 0, 0, err
 	}
-	ttl := uint32(maybeTtl)
 
-	return 4, ttl, nil
+	return 4, value, nil
 }
 
 func deserializeRdLength(buf []byte, offset int) (int, uint16, error) {
-	maybeRdLength, err := uint16FromBytes(buf[offset : offset+2])
+	if len(buf) < offset+2 {
+		return 0, 0, fmt.Errorf("not enough bytes to read RDLENGTH")
+	}
+
+	value, err := uint16FromBytes(buf[offset : offset+2])
 	if err != nil {
 		return 0, 0, err
 	}
-	rdLength := uint16(maybeRdLength)
 
-	return 2, rdLength, nil
+	return 2, value, nil
 }
@@ -0,0 +1,60 @@
+package dns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func FuzzDeserializeResourceRecord(f *testing.F) {
+	f.Fuzz(func(t *testing.T, data []byte, offset uint16) {
+		if int(offset) > len(data) {
+			return
+		}
+
+		bytesRead, record, err := deserializeResourceRecord(data, int(offset))
+		if err != nil {
+			return
+		}
+
+		if bytesRead < MIN_RESOURCE_RECORD_SIZE || int(offset)+bytesRead > len(data) {
+			t.Fatalf("read %d bytes at offset %d of %d", bytesRead, offset, len(data))
+		}
+
+		serialized, err := record.Serialize()
+		if err != nil {
+			t.Fatalf("failed to serialize parsed record: %v", err)
+		}
+
+		bytesRead, reparsed, err := deserializeResourceRecord(serialized, 0)
+		if err != nil {
+			t.Fatalf("failed to parse serialized record: %v", err)
+		}
+
+		lowerRecordNames(record)
+		lowerRecordNames(reparsed)
+		if bytesRead != len(serialized) || !reflect.DeepEqual(record, reparsed) {
+			t.Fatalf("record changed in round trip:\n%+v\n%+v", record, reparsed)
+		}
+	})
+}
+
+func TestDeserializeResourceRecordRejectsTruncatedFields(t *testing.T) {
+	// www. 60 IN A 192.0.2.1
+	data := []byte{3, 'w', 'w', 'w', 0, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 60, 0x00, 4, 192, 0, 2, 1}
+
+	_, record, err := deserializeResourceRecord(data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.String() != "www. 60 IN A 192.0.2.1" {
+		t.Fatalf("record is %s", record.String())
+	}
+
+	// Cut off within the name, TYPE, CLASS, TTL, RDLENGTH and RDATA
+	for size := 0; size < len(data); size++ {
+		_, _, err := deserializeResourceRecord(data[:size], 0)
+		if err == nil {
+			t.Errorf("record cut off after %d bytes was accepted", size)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The largest message that fits into the two byte length prefix used over
+// TCP.
+const MAX_TCP_MESSAGE_SIZE = 0xFFFF
+
+// Reads a single message from a TCP stream. Over TCP every message is
+// prefixed with a two byte length field, which gives the message length
+// excluding the two byte length field itself (RFC 1035 section 4.2.2).
+func ReadTCPMessage(r io.Reader) ([]byte, error) {
+	lengthBuf := make([]byte, 2)
+	_, err := io.ReadFull(r, lengthBuf)
+	if err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint16(lengthBuf)
+	buf := make([]byte, length)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// Writes a single message to a TCP stream, prefixed with its length. The
+// message is written with a single call to Write, so concurrent writers only
+// need to serialize their calls.
+func WriteTCPMessage(w io.Writer, data []byte) error {
+	if len(data) > MAX_TCP_MESSAGE_SIZE {
+		return fmt.Errorf("message length %d exceeds maximum of %d", len(data), MAX_TCP_MESSAGE_SIZE)
+	}
+
+	buf := make([]byte, 0, 2+len(data))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(data)))
+	buf = append(buf, data...)
+
+	_, err := w.Write(buf)
+	return err
+}
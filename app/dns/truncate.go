@@ -0,0 +1,134 @@
+package dns
+
+// Serializes the message, leaving out whole RRsets until it fits into
+// maxSize bytes. The OPT record is always kept. If an RRset of the answer or
+// authority section had to be left out the TC flag is set, so that the client
+// knows to retry over TCP. Leaving out additional records doesn't set TC,
+// since they aren't needed to answer the question (RFC 2181 section 9).
+//
+// The section counts of the serialized header match the records that were
+// written; the message itself is not modified.
+func (m *Message) SerializeTruncated(maxSize int) ([]byte, error) {
+	serialized, err := m.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(serialized) <= maxSize {
+		return serialized, nil
+	}
+
+	// The OPT record must be kept, so room is reserved for it up front. Its
+	// owner is the root, which never benefits from compression.
+	var opt *ResourceRecord
+	optSize := 0
+	additional := make([]ResourceRecord, 0, len(m.Additional))
+	for i, record := range m.Additional {
+		if record.Type == TYPE_OPT && opt == nil {
+			opt = &m.Additional[i]
+
+			optSerialized, err := opt.Serialize()
+			if err != nil {
+				return nil, err
+			}
+			optSize = len(optSerialized)
+			continue
+		}
+
+		additional = append(additional, record)
+	}
+
+	limit := maxSize - optSize
+	w := newMessageWriter(true)
+	header := m.Header
+
+	w.writeBytes(header.Serialize())
+	err = serializeQuestions(w, m.Questions)
+	if err != nil {
+		return nil, err
+	}
+
+	answerCount, answersComplete, err := serializeRRSetsWithin(w, m.Answers, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	authorityCount := 0
+	authorityComplete := false
+	if answersComplete {
+		authorityCount, authorityComplete, err = serializeRRSetsWithin(w, m.Authority, limit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	additionalCount := 0
+	if authorityComplete {
+		additionalCount, _, err = serializeRRSetsWithin(w, additional, limit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opt != nil {
+		err = opt.serializeTo(w)
+		if err != nil {
+			return nil, err
+		}
+		additionalCount += 1
+	}
+
+	header.Flags.TC = header.Flags.TC || !answersComplete || !authorityComplete
+	header.ANCOUNT = uint16(answerCount)
+	header.NSCOUNT = uint16(authorityCount)
+	header.ARCOUNT = uint16(additionalCount)
+
+	buf := w.bytes()
+	copy(buf, header.Serialize())
+
+	return buf, nil
+}
+
+// Writes as many whole RRsets of the records as fit within limit bytes.
+// Returns the number of records written and whether all of them were.
+func serializeRRSetsWithin(w *messageWriter, records []ResourceRecord, limit int) (int, bool, error) {
+	written := 0
+
+	for written < len(records) {
+		rrSetEnd := endOfRRSet(records, written)
+		rollbackOffset := len(w.buf)
+
+		err := serializeResourceRecords(w, records[written:rrSetEnd])
+		if err != nil {
+			return 0, false, err
+		}
+
+		if len(w.buf) > limit {
+			w.rollback(rollbackOffset)
+			return written, false, nil
+		}
+
+		written = rrSetEnd
+	}
+
+	return written, true, nil
+}
+
+// Returns the index after the last record of the RRset starting at start. An
+// RRset is a run of records with the same owner name, type and class.
+func endOfRRSet(records []ResourceRecord, start int) int {
+	first := &records[start]
+	ownerKey := nameKey(first.Name.Labels)
+
+	end := start + 1
+	for end < len(records) {
+		record := &records[end]
+		if record.Type != first.Type || record.Class != first.Class || nameKey(record.Name.Labels) != ownerKey {
+			break
+		}
+
+		end++
+	}
+
+	return end
+}
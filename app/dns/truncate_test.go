@@ -0,0 +1,218 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func parseRecords(t *testing.T, lines ...string) []ResourceRecord {
+	t.Helper()
+
+	records := make([]ResourceRecord, 0, len(lines))
+	for _, line := range lines {
+		record, err := ParseResourceRecord(line, nil)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", line, err)
+		}
+
+		records = append(records, *record)
+	}
+
+	return records
+}
+
+// Builds a response with an RRset of count A records for a.example.com.,
+// followed by an RRset of two large TXT records for b.example.com., and an
+// NS record in the authority section. The glue of the NS record is in the
+// additional section, together with an OPT record advertising 4096 bytes.
+func makeLargeResponse(t *testing.T, count int) *Message {
+	t.Helper()
+
+	answers := make([]string, 0, count+2)
+	for i := 0; i < count; i++ {
+		answers = append(answers, fmt.Sprintf("a.example.com. 60 IN A 192.0.2.%d", i+1))
+	}
+
+	text := `"` + strings.Repeat("x", 200) + `"`
+	answers = append(answers,
+		"b.example.com. 60 IN TXT "+text,
+		"b.example.com. 60 IN TXT "+text+" "+text)
+
+	question, err := ParseDomainName("a.example.com.", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Message{
+		Header:     Header{ID: 1, Flags: Flags{QR: true}},
+		Questions:  []Question{{Name: *question, Type: TYPE_A, Class: CLASS_IN}},
+		Answers:    parseRecords(t, answers...),
+		Authority:  parseRecords(t, "example.com. 3600 IN NS ns1.example.com."),
+		Additional: parseRecords(t, "ns1.example.com. 3600 IN A 192.0.2.53"),
+	}
+	m.SetEDNS(&EDNS{UDPSize: 4096})
+
+	m.Header.QDCOUNT = uint16(len(m.Questions))
+	m.Header.ANCOUNT = uint16(len(m.Answers))
+	m.Header.NSCOUNT = uint16(len(m.Authority))
+	m.Header.ARCOUNT = uint16(len(m.Additional))
+
+	return m
+}
+
+func serializeTruncated(t *testing.T, m *Message, maxSize int) *Message {
+	t.Helper()
+
+	serialized, err := m.SerializeTruncated(maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(serialized) > maxSize {
+		t.Fatalf("serialized to %d bytes, limit is %d", len(serialized), maxSize)
+	}
+
+	truncated, err := DeserializeMessage(serialized)
+	if err != nil {
+		t.Fatalf("truncated message doesn't parse: %v", err)
+	}
+
+	return truncated
+}
+
+func TestSerializeTruncatedKeepsMessagesThatFit(t *testing.T) {
+	m := makeLargeResponse(t, 4)
+
+	truncated := serializeTruncated(t, m, 4096)
+	if truncated.Header.Flags.TC {
+		t.Error("TC is set")
+	}
+	if len(truncated.Answers) != len(m.Answers) || len(truncated.Authority) != 1 || len(truncated.Additional) != 2 {
+		t.Errorf("sections have %d, %d and %d records", len(truncated.Answers), len(truncated.Authority), len(truncated.Additional))
+	}
+}
+
+func TestSerializeTruncatedStopsAtRRSetBoundaries(t *testing.T) {
+	m := makeLargeResponse(t, 4)
+
+	full, err := m.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The TXT RRset is about 600 bytes, so this leaves room for the A
+	// records and the first TXT record, but not the whole TXT RRset
+	truncated := serializeTruncated(t, m, len(full)-200)
+
+	if !truncated.Header.Flags.TC {
+		t.Error("TC is not set")
+	}
+	if len(truncated.Answers) != 4 {
+		t.Fatalf("kept %d answers, expected the 4 A records", len(truncated.Answers))
+	}
+	for _, record := range truncated.Answers {
+		if record.Type != TYPE_A {
+			t.Errorf("kept a %v record", record.Type)
+		}
+	}
+	if len(truncated.Authority) != 0 {
+		t.Errorf("kept %d authority records after truncating the answers", len(truncated.Authority))
+	}
+
+	// The OPT record is always kept
+	edns := truncated.EDNS()
+	if edns == nil || edns.UDPSize != 4096 || len(truncated.Additional) != 1 {
+		t.Errorf("additional section is %v", truncated.Additional)
+	}
+	if int(truncated.Header.ANCOUNT) != len(truncated.Answers) || int(truncated.Header.ARCOUNT) != len(truncated.Additional) {
+		t.Errorf("header counts %d and %d don't match the records", truncated.Header.ANCOUNT, truncated.Header.ARCOUNT)
+	}
+}
+
+func TestSerializeTruncatedLeavesOutAdditionalWithoutTC(t *testing.T) {
+	m := makeLargeResponse(t, 4)
+
+	full, err := m.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the glue record doesn't fit
+	truncated := serializeTruncated(t, m, len(full)-1)
+
+	if truncated.Header.Flags.TC {
+		t.Error("TC is set for a missing additional record")
+	}
+	if len(truncated.Answers) != len(m.Answers) || len(truncated.Authority) != 1 {
+		t.Errorf("kept %d answers and %d authority records", len(truncated.Answers), len(truncated.Authority))
+	}
+	if len(truncated.Additional) != 1 || truncated.Additional[0].Type != TYPE_OPT {
+		t.Errorf("additional section is %v", truncated.Additional)
+	}
+}
+
+func TestSerializeTruncatedHonorsEDNSPayloadSize(t *testing.T) {
+	// About 1600 bytes of A records: more than the 1232 bytes the client
+	// advertises, less than the 4096 bytes the server would accept
+	m := makeLargeResponse(t, 100)
+	m.Answers = m.Answers[:100]
+	m.Header.ANCOUNT = 100
+
+	tests := []struct {
+		name    string
+		edns    *EDNS
+		maxSize int
+	}{
+		{"without EDNS", nil, MIN_UDP_PAYLOAD_SIZE},
+		{"below the minimum", &EDNS{UDPSize: 256}, MIN_UDP_PAYLOAD_SIZE},
+		{"1232 bytes", &EDNS{UDPSize: 1232}, 1232},
+		{"4096 bytes", &EDNS{UDPSize: 4096}, 4096},
+		// Capped at the size the server advertises
+		{"65535 bytes", &EDNS{UDPSize: 65535}, 4096},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := &Message{}
+			request.SetEDNS(test.edns)
+
+			maxSize := MaxUDPPayloadSize(request, 4096)
+			if maxSize != test.maxSize {
+				t.Fatalf("payload size is %d, expected %d", maxSize, test.maxSize)
+			}
+
+			truncated := serializeTruncated(t, m, maxSize)
+			fits := test.maxSize == 4096
+			if truncated.Header.Flags.TC == fits {
+				t.Errorf("TC is %v", truncated.Header.Flags.TC)
+			}
+			if fits != (len(truncated.Answers) == 100) {
+				t.Errorf("kept %d answers", len(truncated.Answers))
+			}
+		})
+	}
+}
+
+func TestSerializeTruncatedCapsEDNSPayloadSize(t *testing.T) {
+	// About 4600 bytes, which a client advertising the largest payload size
+	// would accept, but which doesn't fit into what the server is willing to
+	// send
+	m := makeLargeResponse(t, 250)
+
+	request := &Message{}
+	request.SetEDNS(&EDNS{UDPSize: 65535})
+
+	maxSize := MaxUDPPayloadSize(request, 4096)
+	if maxSize != 4096 {
+		t.Fatalf("payload size is %d, expected the server's 4096", maxSize)
+	}
+
+	truncated := serializeTruncated(t, m, maxSize)
+	if !truncated.Header.Flags.TC {
+		t.Error("TC is not set")
+	}
+	if len(truncated.Answers) != 250 {
+		t.Errorf("kept %d answers, expected the 250 A records", len(truncated.Answers))
+	}
+}
@@ -0,0 +1,196 @@
+package dns
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Decides the order in which the upstreams of a ForwardingResolver are tried.
+type UpstreamPolicy int
+
+const (
+	// Always try the upstreams in the configured order, falling back to the
+	// next one when an upstream fails.
+	PolicySequential UpstreamPolicy = iota
+	// Start with the next upstream for every query.
+	PolicyRoundRobin
+	// Try the upstreams in a random order.
+	PolicyRandom
+	// Prefer the upstream that has been answering the fastest.
+	PolicyLowestLatency
+)
+
+// After this many consecutive failures an upstream is considered down.
+const UPSTREAM_MAX_FAILURES = 3
+
+// How long an upstream which is down is only used as a last resort.
+const UPSTREAM_DOWN_DURATION = 30 * time.Second
+
+// The weight of a new measurement in the smoothed round trip time, as used
+// for TCP (RFC 6298).
+const upstreamRttAlpha = 0.125
+
+var upstreamPolicyNames = map[string]UpstreamPolicy{
+	"sequential":     PolicySequential,
+	"round-robin":    PolicyRoundRobin,
+	"random":         PolicyRandom,
+	"lowest-latency": PolicyLowestLatency,
+}
+
+func ParseUpstreamPolicy(name string) (UpstreamPolicy, error) {
+	policy, ok := upstreamPolicyNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown upstream policy %q", name)
+	}
+
+	return policy, nil
+}
+
+func (p UpstreamPolicy) String() string {
+	for name, policy := range upstreamPolicyNames {
+		if policy == p {
+			return name
+		}
+	}
+
+	return fmt.Sprintf("UpstreamPolicy(%d)", int(p))
+}
+
+// An upstream resolver together with what we have learned about its health.
+type upstream struct {
+	addr *net.UDPAddr
+
+	mutex sync.Mutex
+	// Smoothed round trip time, zero until the first answer.
+	rtt time.Duration
+	// Number of failures since the last successful answer.
+	failures int
+	// The upstream is down until this time.
+	downUntil time.Time
+	// The total number of queries the upstream answered, and failed to.
+	answered uint64
+	failed   uint64
+}
+
+// What is known about an upstream, for monitoring.
+type UpstreamStats struct {
+	Address string
+	// Smoothed round trip time, zero until the first answer.
+	RTT time.Duration
+	// Queries the upstream answered, and queries it failed to answer.
+	Answers  uint64
+	Failures uint64
+	// Whether the upstream is considered down.
+	Down bool
+}
+
+func (u *upstream) recordSuccess(rtt time.Duration) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.rtt == 0 {
+		u.rtt = rtt
+	} else {
+		u.rtt = time.Duration((1-upstreamRttAlpha)*float64(u.rtt) + upstreamRttAlpha*float64(rtt))
+	}
+
+	u.answered++
+	u.failures = 0
+	u.downUntil = time.Time{}
+}
+
+func (u *upstream) recordFailure(now time.Time) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.failed++
+	u.failures++
+	if u.failures >= UPSTREAM_MAX_FAILURES {
+		if u.downUntil.IsZero() {
+			fmt.Printf("Upstream %s failed %d times, marking it down\n", u.addr, u.failures)
+		}
+		u.downUntil = now.Add(UPSTREAM_DOWN_DURATION)
+	}
+}
+
+func (u *upstream) isDown(now time.Time) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return now.Before(u.downUntil)
+}
+
+func (u *upstream) stats(now time.Time) UpstreamStats {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return UpstreamStats{
+		Address:  u.addr.String(),
+		RTT:      u.rtt,
+		Answers:  u.answered,
+		Failures: u.failed,
+		Down:     now.Before(u.downUntil),
+	}
+}
+
+func (u *upstream) smoothedRtt() time.Duration {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.rtt
+}
+
+// Orders the upstreams according to a policy.
+type upstreamSelector struct {
+	upstreams []*upstream
+	policy    UpstreamPolicy
+	// Where the next round robin starts.
+	next atomic.Uint32
+	// Returns the current time, which tests control.
+	now func() time.Time
+}
+
+// Returns the upstreams in the order they should be tried for a query.
+// Upstreams which are down come last, so they are only used when all others
+// fail.
+func (s *upstreamSelector) order() []*upstream {
+	ordered := make([]*upstream, len(s.upstreams))
+	copy(ordered, s.upstreams)
+
+	switch s.policy {
+	case PolicyRoundRobin:
+		start := int(s.next.Add(1)-1) % len(ordered)
+		ordered = append(ordered[start:], ordered[:start]...)
+	case PolicyRandom:
+		rand.Shuffle(len(ordered), func(i, j int) {
+			ordered[i], ordered[j] = ordered[j], ordered[i]
+		})
+	case PolicyLowestLatency:
+		rtts := make(map[*upstream]time.Duration, len(ordered))
+		for _, u := range ordered {
+			rtts[u] = u.smoothedRtt()
+		}
+
+		// Upstreams without a measurement yet sort first, so that they get one
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return rtts[ordered[i]] < rtts[ordered[j]]
+		})
+	}
+
+	now := s.now()
+	down := make(map[*upstream]bool, len(ordered))
+	for _, u := range ordered {
+		down[u] = u.isDown(now)
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return !down[ordered[i]] && down[ordered[j]]
+	})
+
+	return ordered
+}
@@ -0,0 +1,208 @@
+package dns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Upstreams whose clock only moves when the test advances it.
+type upstreamTest struct {
+	t        *testing.T
+	selector *upstreamSelector
+	now      time.Time
+}
+
+func newUpstreamTest(t *testing.T, policy UpstreamPolicy, count int) *upstreamTest {
+	upstreams := make([]*upstream, 0, count)
+	for i := 0; i < count; i++ {
+		upstreams = append(upstreams, &upstream{addr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, byte(i+1)), Port: 53}})
+	}
+
+	u := &upstreamTest{t: t, now: time.Unix(1700000000, 0)}
+	u.selector = &upstreamSelector{
+		upstreams: upstreams,
+		policy:    policy,
+		now:       func() time.Time { return u.now },
+	}
+
+	return u
+}
+
+func (u *upstreamTest) advance(d time.Duration) {
+	u.now = u.now.Add(d)
+}
+
+// Returns the indexes of the upstreams in the order they are to be tried.
+func (u *upstreamTest) order() []int {
+	indexes := make([]int, 0, len(u.selector.upstreams))
+	for _, ordered := range u.selector.order() {
+		for i, upstream := range u.selector.upstreams {
+			if upstream == ordered {
+				indexes = append(indexes, i)
+			}
+		}
+	}
+
+	return indexes
+}
+
+func (u *upstreamTest) expectOrder(expected ...int) {
+	u.t.Helper()
+
+	if order := u.order(); !reflect.DeepEqual(order, expected) {
+		u.t.Errorf("order is %v, expected %v", order, expected)
+	}
+}
+
+func (u *upstreamTest) fail(i int, times int) {
+	for j := 0; j < times; j++ {
+		u.selector.upstreams[i].recordFailure(u.now)
+	}
+}
+
+func TestUpstreamSelectorOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy UpstreamPolicy
+		rtts   []time.Duration
+		// The orders of consecutive queries.
+		expected [][]int
+	}{
+		{"sequential", PolicySequential, nil, [][]int{{0, 1, 2}, {0, 1, 2}}},
+		{"round robin", PolicyRoundRobin, nil, [][]int{{0, 1, 2}, {1, 2, 0}, {2, 0, 1}, {0, 1, 2}}},
+		{
+			"lowest latency",
+			PolicyLowestLatency,
+			[]time.Duration{30 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond},
+			[][]int{{1, 2, 0}},
+		},
+		{
+			// Upstreams without a measurement are tried first, to get one
+			"lowest latency unmeasured",
+			PolicyLowestLatency,
+			[]time.Duration{30 * time.Millisecond, 0, 20 * time.Millisecond},
+			[][]int{{1, 2, 0}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			u := newUpstreamTest(t, test.policy, 3)
+			for i, rtt := range test.rtts {
+				if rtt != 0 {
+					u.selector.upstreams[i].recordSuccess(rtt)
+				}
+			}
+
+			for _, expected := range test.expected {
+				u.expectOrder(expected...)
+			}
+		})
+	}
+}
+
+func TestUpstreamSelectorRandomOrder(t *testing.T) {
+	u := newUpstreamTest(t, PolicyRandom, 3)
+
+	first := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		order := u.order()
+		if len(order) != 3 || order[0] == order[1] || order[0] == order[2] || order[1] == order[2] {
+			t.Fatalf("order %v isn't a permutation of the upstreams", order)
+		}
+
+		first[order[0]] = true
+	}
+
+	if len(first) != 3 {
+		t.Errorf("only %v were tried first in 100 queries", first)
+	}
+}
+
+func TestUpstreamSelectorMarksUpstreamsDown(t *testing.T) {
+	policies := []UpstreamPolicy{PolicySequential, PolicyRoundRobin, PolicyLowestLatency}
+
+	for _, policy := range policies {
+		t.Run(policy.String(), func(t *testing.T) {
+			u := newUpstreamTest(t, policy, 3)
+			first := u.selector.upstreams[0]
+
+			// Failures below the limit don't count against the upstream
+			u.fail(0, UPSTREAM_MAX_FAILURES-1)
+			if first.isDown(u.now) {
+				t.Fatal("upstream is down before reaching the failure limit")
+			}
+
+			u.fail(0, 1)
+			if !first.isDown(u.now) {
+				t.Fatal("upstream isn't down after reaching the failure limit")
+			}
+
+			// Down upstreams are still tried, but after all others
+			for i := 0; i < 3; i++ {
+				if order := u.order(); len(order) != 3 || order[2] != 0 {
+					t.Fatalf("order is %v, expected the down upstream last", order)
+				}
+			}
+
+			u.advance(UPSTREAM_DOWN_DURATION - time.Second)
+			if !first.isDown(u.now) {
+				t.Fatal("upstream came back before its down time was over")
+			}
+
+			u.advance(time.Second)
+			if first.isDown(u.now) {
+				t.Fatal("upstream is still down after its down time")
+			}
+
+			stats := first.stats(u.now)
+			if stats.Down || stats.Failures != UPSTREAM_MAX_FAILURES || stats.Answers != 0 {
+				t.Errorf("stats are %+v", stats)
+			}
+		})
+	}
+}
+
+func TestUpstreamRecoversAfterAnswering(t *testing.T) {
+	u := newUpstreamTest(t, PolicySequential, 2)
+	first := u.selector.upstreams[0]
+
+	u.fail(0, UPSTREAM_MAX_FAILURES)
+	u.expectOrder(1, 0)
+
+	// Tried as a last resort, the upstream answers and is up again at once
+	first.recordSuccess(10 * time.Millisecond)
+	u.expectOrder(0, 1)
+
+	// Its failures were forgotten, so a single one doesn't mark it down
+	u.fail(0, 1)
+	u.expectOrder(0, 1)
+
+	// Failing again while down extends the down time
+	u.fail(0, UPSTREAM_MAX_FAILURES)
+	u.advance(UPSTREAM_DOWN_DURATION / 2)
+	u.fail(0, 1)
+	u.advance(UPSTREAM_DOWN_DURATION / 2)
+	u.expectOrder(1, 0)
+
+	u.advance(UPSTREAM_DOWN_DURATION / 2)
+	u.expectOrder(0, 1)
+}
+
+func TestUpstreamSmoothsRTT(t *testing.T) {
+	u := newUpstreamTest(t, PolicyLowestLatency, 1)
+	upstream := u.selector.upstreams[0]
+
+	upstream.recordSuccess(80 * time.Millisecond)
+	if rtt := upstream.smoothedRtt(); rtt != 80*time.Millisecond {
+		t.Errorf("RTT is %v after the first answer, expected its 80ms", rtt)
+	}
+
+	// Each answer moves the RTT an eighth of the way towards it
+	upstream.recordSuccess(160 * time.Millisecond)
+	if rtt := upstream.smoothedRtt(); rtt != 90*time.Millisecond {
+		t.Errorf("RTT is %v, expected 90ms", rtt)
+	}
+}
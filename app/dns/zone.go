@@ -0,0 +1,238 @@
+package dns
+
+import (
+	"fmt"
+)
+
+// The data of a zone that this server is authoritative for.
+type Zone struct {
+	// The name of the zone apex, which owns the SOA record.
+	Origin DomainName
+	Class  ResourceRecordClass
+	soa    ResourceRecord
+	rrSets map[rrSetKey][]ResourceRecord
+	// The lower-cased names that exist in the zone, including names which
+	// own no records but have descendants that do (empty non-terminals).
+	names map[string]bool
+}
+
+// Loads a zone from a master file. Relative names in the file are relative to
+// origin, which may be nil if the file sets $ORIGIN itself or only uses
+// absolute names.
+func LoadZone(path string, origin *DomainName) (*Zone, error) {
+	records, err := ParseMasterFile(path, origin)
+	if err != nil {
+		return nil, err
+	}
+
+	zone, err := NewZone(records)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return zone, nil
+}
+
+// Builds a zone from its records. The zone must have exactly one SOA record,
+// which determines its origin, and NS records at the origin.
+func NewZone(records []ResourceRecord) (*Zone, error) {
+	var soa *ResourceRecord
+	for i, record := range records {
+		if record.Type != TYPE_SOA {
+			continue
+		}
+
+		if soa != nil {
+			return nil, fmt.Errorf("zone has more than one SOA record")
+		}
+		soa = &records[i]
+	}
+
+	if soa == nil {
+		return nil, fmt.Errorf("zone has no SOA record")
+	}
+
+	zone := &Zone{
+		Origin: soa.Name,
+		Class:  soa.Class,
+		soa:    *soa,
+		rrSets: make(map[rrSetKey][]ResourceRecord),
+		names:  make(map[string]bool),
+	}
+
+	// The number of RRsets owned by each name
+	rrSetCounts := make(map[string]int)
+
+	for _, rrSet := range groupRRSets(records) {
+		first := &rrSet[0]
+		if !first.Name.IsSubdomainOf(&zone.Origin) {
+			return nil, fmt.Errorf("%s is outside of zone %s", first.Name.String(), zone.Origin.String())
+		}
+
+		if first.Class != zone.Class {
+			return nil, fmt.Errorf("%s %s has class %s, but the zone has class %s", first.Name.String(), first.Type, first.Class, zone.Class)
+		}
+
+		key := makeRRSetKey(&first.Name, first.Type, first.Class)
+		zone.rrSets[key] = rrSet
+		rrSetCounts[key.name]++
+
+		// The owner and all names between it and the origin exist
+		for name := first.Name; len(name.Labels) >= len(zone.Origin.Labels); name = name.Parent() {
+			zone.names[nameKey(name.Labels)] = true
+			if len(name.Labels) == 0 {
+				break
+			}
+		}
+	}
+
+	if zone.rrSet(&zone.Origin, TYPE_NS) == nil {
+		return nil, fmt.Errorf("zone %s has no NS records at its origin", zone.Origin.String())
+	}
+
+	for key, rrSet := range zone.rrSets {
+		// A CNAME can't coexist with other data (RFC 1034 section 3.6.2)
+		if key.rrType == TYPE_CNAME && rrSetCounts[key.name] > 1 {
+			return nil, fmt.Errorf("%s has a CNAME record and other data", rrSet[0].Name.String())
+		}
+	}
+
+	return zone, nil
+}
+
+// Returns the RRset of the type owned by the name, or nil if there is none.
+func (z *Zone) rrSet(name *DomainName, rrType ResourceRecordType) []ResourceRecord {
+	return z.rrSets[makeRRSetKey(name, rrType, z.Class)]
+}
+
+// Whether the question is about a name within the zone.
+func (z *Zone) contains(question *Question) bool {
+	return question.Class == z.Class && question.Name.IsSubdomainOf(&z.Origin)
+}
+
+// The answer of a zone to a question.
+type zoneAnswer struct {
+	answers    []ResourceRecord
+	authority  []ResourceRecord
+	additional []ResourceRecord
+	returnCode ResponseCode
+	// Whether the answer is authoritative; referrals to a child zone aren't.
+	authoritative bool
+}
+
+// Answers a question about a name within the zone, following the algorithm
+// of RFC 1034 section 4.3.2 without wildcards. CNAMEs are followed as long as
+// they lead to names within the zone, and until they loop.
+func (z *Zone) resolve(question *Question) *zoneAnswer {
+	answer := &zoneAnswer{
+		answers:       make([]ResourceRecord, 0),
+		authority:     make([]ResourceRecord, 0),
+		additional:    make([]ResourceRecord, 0),
+		returnCode:    RCodeNoError,
+		authoritative: true,
+	}
+	name := question.Name
+	visited := make(map[string]bool)
+
+	for i := 0; i <= MAX_CNAME_CHAIN && !visited[nameKey(name.Labels)]; i++ {
+		visited[nameKey(name.Labels)] = true
+
+		if cut := z.findDelegation(&name); cut != nil {
+			// The name belongs to a child zone, refer to its name servers
+			nsRecords := z.rrSet(cut, TYPE_NS)
+			answer.authority = append(answer.authority, nsRecords...)
+			answer.additional = append(answer.additional, z.addressesOf(nsRecords)...)
+			answer.authoritative = len(answer.answers) > 0
+			return answer
+		}
+
+		if !z.names[nameKey(name.Labels)] {
+			answer.returnCode = RCodeNameError
+			answer.authority = append(answer.authority, z.negativeSOA())
+			return answer
+		}
+
+		records := z.rrSet(&name, question.Type)
+		if records != nil {
+			answer.answers = append(answer.answers, records...)
+			answer.additional = append(answer.additional, z.addressesOf(records)...)
+			return answer
+		}
+
+		cnames := z.rrSet(&name, TYPE_CNAME)
+		if cnames == nil {
+			// The name exists, but has no data of the type (NODATA)
+			answer.authority = append(answer.authority, z.negativeSOA())
+			return answer
+		}
+
+		answer.answers = append(answer.answers, cnames...)
+
+		target := cnames[0].RData.(*CNAMEData).Target
+		if !target.IsSubdomainOf(&z.Origin) {
+			// The resolver of the client has to chase names in other zones
+			return answer
+		}
+
+		name = target
+	}
+
+	return answer
+}
+
+// Returns the zone cut at or above the name, i.e. the closest name below the
+// origin that owns NS records, or nil if the name isn't delegated.
+func (z *Zone) findDelegation(name *DomainName) *DomainName {
+	for labels := len(z.Origin.Labels) + 1; labels <= len(name.Labels); labels++ {
+		candidate := DomainName{Labels: name.Labels[len(name.Labels)-labels:]}
+		if z.rrSet(&candidate, TYPE_NS) != nil {
+			return &candidate
+		}
+	}
+
+	return nil
+}
+
+// The SOA record to include in negative answers. Its TTL is capped by the
+// MINIMUM field, which says for how long negative answers may be cached (RFC
+// 2308 section 3).
+func (z *Zone) negativeSOA() ResourceRecord {
+	soa := z.soa
+	minimum := soa.RData.(*SOAData).Minimum
+	if minimum < soa.TTL {
+		soa.TTL = minimum
+	}
+
+	return soa
+}
+
+// Returns the addresses within the zone of the hosts the records refer to,
+// e.g. the name servers of NS records or the exchanges of MX records
+// (RFC 1035 section 4.3.2, step 6). These include the glue records of
+// delegations.
+func (z *Zone) addressesOf(records []ResourceRecord) []ResourceRecord {
+	addresses := make([]ResourceRecord, 0)
+
+	for _, record := range records {
+		var host *DomainName
+		switch data := record.RData.(type) {
+		case *NSData:
+			host = &data.Host
+		case *MXData:
+			host = &data.Exchange
+		case *SRVData:
+			host = &data.Target
+		default:
+			continue
+		}
+
+		if !host.IsSubdomainOf(&z.Origin) {
+			continue
+		}
+
+		addresses = append(addresses, z.rrSet(host, TYPE_A)...)
+		addresses = append(addresses, z.rrSet(host, TYPE_AAAA)...)
+	}
+
+	return addresses
+}
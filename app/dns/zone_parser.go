@@ -0,0 +1,377 @@
+package dns
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// How deeply $INCLUDE directives may nest.
+const MAX_INCLUDE_DEPTH = 8
+
+// A token of a master file. Quoted tokens keep their escapes, but not the
+// surrounding quotes.
+type zoneToken struct {
+	text   string
+	quoted bool
+}
+
+// An entry of a master file, which may span several physical lines when
+// parentheses are used.
+type zoneLine struct {
+	tokens []zoneToken
+	// Whether the entry starts with a blank, in which case it has no owner
+	// and the previous owner is used.
+	indented bool
+	// The physical line the entry starts on, for error messages.
+	lineNumber int
+}
+
+// Splits the contents of a master file into entries (RFC 1035 section 5.1).
+// Comments are dropped, and entries spanning several lines within
+// parentheses are joined.
+func tokenizeZone(content string) ([]zoneLine, error) {
+	lines := make([]zoneLine, 0)
+	current := zoneLine{lineNumber: 1}
+	token := make([]byte, 0)
+	inToken := false
+	parenDepth := 0
+	lineNumber := 1
+	atLineStart := true
+
+	endToken := func() {
+		if inToken {
+			current.tokens = append(current.tokens, zoneToken{text: string(token)})
+			token = token[:0]
+			inToken = false
+		}
+	}
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+
+		if atLineStart {
+			atLineStart = false
+			if parenDepth == 0 {
+				current = zoneLine{
+					indented:   c == ' ' || c == '\t',
+					lineNumber: lineNumber,
+				}
+			}
+		}
+
+		switch {
+		case c == '\n':
+			endToken()
+			lineNumber++
+			atLineStart = true
+
+			if parenDepth == 0 {
+				if len(current.tokens) > 0 {
+					lines = append(lines, current)
+				}
+				current = zoneLine{}
+			}
+		case c == ' ' || c == '\t' || c == '\r':
+			endToken()
+		case c == ';':
+			endToken()
+			for i+1 < len(content) && content[i+1] != '\n' {
+				i++
+			}
+		case c == '(':
+			endToken()
+			parenDepth++
+		case c == ')':
+			endToken()
+			parenDepth--
+			if parenDepth < 0 {
+				return nil, fmt.Errorf("line %d: unbalanced \")\"", lineNumber)
+			}
+		case c == '"':
+			endToken()
+
+			quoted := make([]byte, 0)
+			closed := false
+			for i+1 < len(content) {
+				i++
+				if content[i] == '\\' && i+1 < len(content) {
+					quoted = append(quoted, content[i], content[i+1])
+					i++
+					continue
+				}
+				if content[i] == '"' {
+					closed = true
+					break
+				}
+				if content[i] == '\n' {
+					break
+				}
+				quoted = append(quoted, content[i])
+			}
+
+			if !closed {
+				return nil, fmt.Errorf("line %d: unterminated quoted string", lineNumber)
+			}
+
+			current.tokens = append(current.tokens, zoneToken{text: string(quoted), quoted: true})
+		case c == '\\' && i+1 < len(content):
+			// Escaped characters lose their special meaning
+			token = append(token, c, content[i+1])
+			inToken = true
+			i++
+		default:
+			token = append(token, c)
+			inToken = true
+		}
+	}
+
+	endToken()
+
+	if parenDepth > 0 {
+		return nil, fmt.Errorf("line %d: unbalanced \"(\"", lineNumber)
+	}
+
+	if len(current.tokens) > 0 {
+		lines = append(lines, current)
+	}
+
+	return lines, nil
+}
+
+// Parses master files into resource records, keeping track of the state that
+// carries over from one entry to the next.
+type zoneParser struct {
+	fileName string
+	// Relative names are relative to the origin.
+	origin *DomainName
+	// The TTL set by $TTL, used for entries without one (RFC 2308 section 4).
+	defaultTTL    uint32
+	hasDefaultTTL bool
+	// Entries without an owner, TTL or class take them from the last entry.
+	lastOwner  *DomainName
+	lastTTL    uint32
+	hasLastTTL bool
+	lastClass  ResourceRecordClass
+
+	includeDepth int
+	records      []ResourceRecord
+}
+
+// Parses a master file (RFC 1035 section 5) into its resource records.
+// Relative names are relative to origin until a $ORIGIN directive changes
+// it; origin may be nil if the file sets its own origin or only uses absolute
+// names.
+func ParseMasterFile(path string, origin *DomainName) ([]ResourceRecord, error) {
+	parser := &zoneParser{
+		origin:    origin,
+		lastClass: CLASS_IN,
+		records:   make([]ResourceRecord, 0),
+	}
+
+	err := parser.parseFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parser.records, nil
+}
+
+func (p *zoneParser) parseFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	p.fileName = path
+	return p.parse(string(content))
+}
+
+func (p *zoneParser) parse(content string) error {
+	lines, err := tokenizeZone(content)
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.fileName, err)
+	}
+
+	for _, line := range lines {
+		first := line.tokens[0]
+
+		if !line.indented && !first.quoted && strings.HasPrefix(first.text, "$") {
+			err = p.parseDirective(&line)
+		} else {
+			err = p.parseRecord(&line)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", p.fileName, line.lineNumber, err)
+		}
+	}
+
+	return nil
+}
+
+func (p *zoneParser) parseDirective(line *zoneLine) error {
+	directive := strings.ToUpper(line.tokens[0].text)
+	args := line.tokens[1:]
+
+	switch directive {
+	case "$ORIGIN":
+		if len(args) != 1 {
+			return fmt.Errorf("$ORIGIN expects a domain name")
+		}
+
+		origin, err := ParseDomainName(args[0].text, p.origin)
+		if err != nil {
+			return err
+		}
+
+		p.origin = origin
+	case "$TTL":
+		if len(args) != 1 {
+			return fmt.Errorf("$TTL expects a TTL")
+		}
+
+		ttl, err := parseTTL(args[0].text)
+		if err != nil {
+			return err
+		}
+
+		p.defaultTTL = ttl
+		p.hasDefaultTTL = true
+	case "$INCLUDE":
+		if len(args) != 1 && len(args) != 2 {
+			return fmt.Errorf("$INCLUDE expects a file name and an optional origin")
+		}
+
+		return p.include(args)
+	default:
+		return fmt.Errorf("unknown directive %s", line.tokens[0].text)
+	}
+
+	return nil
+}
+
+// Parses an included file. The origin given to $INCLUDE, and any $ORIGIN
+// within the included file, only apply to the included file (RFC 1035
+// section 5.1).
+func (p *zoneParser) include(args []zoneToken) error {
+	if p.includeDepth >= MAX_INCLUDE_DEPTH {
+		return fmt.Errorf("$INCLUDE nested more than %d levels deep", MAX_INCLUDE_DEPTH)
+	}
+
+	path := args[0].text
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(p.fileName), path)
+	}
+
+	included := *p
+	included.includeDepth++
+
+	if len(args) == 2 {
+		origin, err := ParseDomainName(args[1].text, p.origin)
+		if err != nil {
+			return err
+		}
+
+		included.origin = origin
+	}
+
+	err := included.parseFile(path)
+	if err != nil {
+		return err
+	}
+
+	p.records = included.records
+
+	return nil
+}
+
+//	<domain-name> [<TTL>] [<class>] <type> <RDATA>
+//	<blank> [<TTL>] [<class>] <type> <RDATA>
+//
+// The TTL and class may also appear in the opposite order.
+func (p *zoneParser) parseRecord(line *zoneLine) error {
+	tokens := line.tokens
+
+	owner := p.lastOwner
+	if !line.indented {
+		var err error
+		owner, err = ParseDomainName(tokens[0].text, p.origin)
+		if err != nil {
+			return err
+		}
+
+		tokens = tokens[1:]
+	}
+
+	if owner == nil {
+		return fmt.Errorf("record without an owner")
+	}
+
+	var ttl uint32
+	hasTTL := false
+	rrClass := p.lastClass
+	hasClass := false
+
+	for len(tokens) > 0 && !tokens[0].quoted && (!hasTTL || !hasClass) {
+		if !hasTTL && isDigit(tokens[0].text[0]) {
+			value, err := parseTTL(tokens[0].text)
+			if err != nil {
+				return err
+			}
+
+			ttl = value
+			hasTTL = true
+		} else if class, err := ParseResourceRecordClass(tokens[0].text); !hasClass && err == nil {
+			rrClass = class
+			hasClass = true
+		} else {
+			break
+		}
+
+		tokens = tokens[1:]
+	}
+
+	if len(tokens) == 0 {
+		return fmt.Errorf("record without a type")
+	}
+
+	rrType, err := ParseResourceRecordType(tokens[0].text)
+	if err != nil {
+		return err
+	}
+
+	rData, err := parseRecordData(rrType, tokens[1:], p.origin)
+	if err != nil {
+		return fmt.Errorf("%s record: %w", rrType, err)
+	}
+
+	if !hasTTL {
+		switch {
+		case p.hasDefaultTTL:
+			ttl = p.defaultTTL
+		case p.hasLastTTL:
+			ttl = p.lastTTL
+		case rrType == TYPE_SOA:
+			ttl = rData.(*SOAData).Minimum
+		default:
+			return fmt.Errorf("record without a TTL and no $TTL")
+		}
+	}
+
+	p.lastOwner = owner
+	p.lastTTL = ttl
+	p.hasLastTTL = true
+	p.lastClass = rrClass
+
+	p.records = append(p.records, ResourceRecord{
+		Name:  *owner,
+		Type:  rrType,
+		Class: rrClass,
+		TTL:   ttl,
+		RData: rData,
+	})
+
+	return nil
+}
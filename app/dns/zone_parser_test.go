@@ -0,0 +1,96 @@
+package dns
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseMasterFile(t *testing.T) {
+	records, err := ParseMasterFile(filepath.Join("testdata", "zones", "example.com.zone"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 2024010101 7200 3600 1209600 300",
+		"example.com. 3600 IN NS ns1.example.com.",
+		"example.com. 3600 IN NS ns2.example.net.",
+		"example.com. 3600 IN MX 10 mail.example.com.",
+		"ns1.example.com. 3600 IN A 192.0.2.53",
+		"mail.example.com. 300 IN A 192.0.2.25",
+		"mail.example.com. 300 IN AAAA 2001:db8::25",
+		"www.example.com. 3600 IN CNAME web.example.com.",
+		"web.example.com. 3600 IN A 192.0.2.80",
+		"external.example.com. 3600 IN CNAME www.example.net.",
+		"loop1.example.com. 3600 IN CNAME loop2.example.com.",
+		"loop2.example.com. 3600 IN CNAME loop1.example.com.",
+		`txt.example.com. 3600 IN TXT "hello; not a comment" "a\"b"`,
+		"a.b.c.example.com. 3600 IN A 192.0.2.1",
+		"sub.example.com. 3600 IN NS ns.sub.example.com.",
+		"sub.example.com. 3600 IN NS ns.example.net.",
+		"ns.sub.example.com. 3600 IN A 192.0.2.54",
+		// $INCLUDE with an origin
+		"hosts.example.com. 3600 IN A 192.0.2.100",
+		"one.hosts.example.com. 3600 IN A 192.0.2.101",
+		// $ORIGIN within an included file
+		"two.other.example.com. 60 IN A 192.0.2.102",
+	}
+
+	if len(records) != len(expected) {
+		t.Errorf("parsed %d records, expected %d", len(records), len(expected))
+	}
+
+	for i := 0; i < len(records) && i < len(expected); i++ {
+		if records[i].String() != expected[i] {
+			t.Errorf("record %d is %q, expected %q", i, records[i].String(), expected[i])
+		}
+	}
+}
+
+func TestParseMasterFileUsesGivenOrigin(t *testing.T) {
+	origin, err := ParseDomainName("example.org.", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parser := &zoneParser{fileName: "test", origin: origin, lastClass: CLASS_IN}
+	err = parser.parse("@ 60 IN A 192.0.2.1\nwww 60 IN A 192.0.2.2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parser.records) != 2 ||
+		parser.records[0].Name.String() != "example.org." ||
+		parser.records[1].Name.String() != "www.example.org." {
+		t.Errorf("records are %v", parser.records)
+	}
+}
+
+func TestParseMasterFileRejectsInvalidInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"unbalanced (", "$ORIGIN example.com.\n@ 60 IN SOA ns1 hostmaster ( 1 2 3 4 5\n", `unbalanced "("`},
+		{"unbalanced )", "$ORIGIN example.com.\n@ 60 IN A 192.0.2.1 )\n", `unbalanced ")"`},
+		{"unterminated quote", "$ORIGIN example.com.\n@ 60 IN TXT \"abc\n", "unterminated quoted string"},
+		{"no TTL", "$ORIGIN example.com.\n@ IN A 192.0.2.1\n", "without a TTL"},
+		{"no owner", " 60 IN A 192.0.2.1\n", "without an owner"},
+		{"no origin", "@ 60 IN A 192.0.2.1\n", "no origin"},
+		{"unknown directive", "$GENERATE 1-2 host$ A 192.0.2.$\n", "unknown directive"},
+		{"invalid address", "$ORIGIN example.com.\n@ 60 IN A 192.0.2\n", "A record"},
+		{"include loop", "$INCLUDE " + filepath.Join("testdata", "zones", "loop.inc") + "\n", "nested more than"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parser := &zoneParser{fileName: "test", lastClass: CLASS_IN}
+			err := parser.parse(test.content)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("error is %v, expected %q", err, test.err)
+			}
+		})
+	}
+}
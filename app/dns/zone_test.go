@@ -0,0 +1,189 @@
+package dns
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func loadTestZone(t *testing.T) *Zone {
+	t.Helper()
+
+	zone, err := LoadZone(filepath.Join("testdata", "zones", "example.com.zone"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return zone
+}
+
+func recordStrings(records []ResourceRecord) []string {
+	strs := make([]string, 0, len(records))
+	for _, record := range records {
+		strs = append(strs, record.String())
+	}
+
+	return strs
+}
+
+const testNegativeSOA = "example.com. 300 IN SOA ns1.example.com. hostmaster.example.com. 2024010101 7200 3600 1209600 300"
+
+func TestZoneResolve(t *testing.T) {
+	zone := loadTestZone(t)
+
+	tests := []struct {
+		name          string
+		qname         string
+		qtype         ResourceRecordType
+		returnCode    ResponseCode
+		authoritative bool
+		answers       []string
+		authority     []string
+		additional    []string
+	}{
+		{
+			name: "answer with additional addresses", qname: "example.com.", qtype: TYPE_MX,
+			authoritative: true,
+			answers:       []string{"example.com. 3600 IN MX 10 mail.example.com."},
+			additional:    []string{"mail.example.com. 300 IN A 192.0.2.25", "mail.example.com. 300 IN AAAA 2001:db8::25"},
+		},
+		{
+			name: "case insensitive", qname: "WEB.Example.COM.", qtype: TYPE_A,
+			authoritative: true,
+			answers:       []string{"web.example.com. 3600 IN A 192.0.2.80"},
+		},
+		{
+			name: "CNAME within the zone", qname: "www.example.com.", qtype: TYPE_A,
+			authoritative: true,
+			answers:       []string{"www.example.com. 3600 IN CNAME web.example.com.", "web.example.com. 3600 IN A 192.0.2.80"},
+		},
+		{
+			name: "CNAME out of the zone", qname: "external.example.com.", qtype: TYPE_A,
+			authoritative: true,
+			answers:       []string{"external.example.com. 3600 IN CNAME www.example.net."},
+		},
+		{
+			name: "CNAME loop", qname: "loop1.example.com.", qtype: TYPE_A,
+			authoritative: true,
+			answers:       []string{"loop1.example.com. 3600 IN CNAME loop2.example.com.", "loop2.example.com. 3600 IN CNAME loop1.example.com."},
+		},
+		{
+			name: "NXDOMAIN", qname: "missing.example.com.", qtype: TYPE_A,
+			returnCode: RCodeNameError, authoritative: true,
+			authority: []string{testNegativeSOA},
+		},
+		{
+			name: "NODATA after CNAME", qname: "www.example.com.", qtype: TYPE_MX,
+			authoritative: true,
+			answers:       []string{"www.example.com. 3600 IN CNAME web.example.com."},
+			authority:     []string{testNegativeSOA},
+		},
+		{
+			name: "NODATA", qname: "web.example.com.", qtype: TYPE_AAAA,
+			authoritative: true,
+			authority:     []string{testNegativeSOA},
+		},
+		{
+			name: "empty non-terminal", qname: "b.c.example.com.", qtype: TYPE_A,
+			authoritative: true,
+			authority:     []string{testNegativeSOA},
+		},
+		{
+			name: "delegation with glue", qname: "host.sub.example.com.", qtype: TYPE_A,
+			authority:  []string{"sub.example.com. 3600 IN NS ns.sub.example.com.", "sub.example.com. 3600 IN NS ns.example.net."},
+			additional: []string{"ns.sub.example.com. 3600 IN A 192.0.2.54"},
+		},
+		{
+			name: "delegation point", qname: "sub.example.com.", qtype: TYPE_NS,
+			authority:  []string{"sub.example.com. 3600 IN NS ns.sub.example.com.", "sub.example.com. 3600 IN NS ns.example.net."},
+			additional: []string{"ns.sub.example.com. 3600 IN A 192.0.2.54"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			qname, err := ParseDomainName(test.qname, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			question := &Question{Name: *qname, Type: test.qtype, Class: CLASS_IN}
+			if !zone.contains(question) {
+				t.Fatal("zone doesn't contain the question")
+			}
+
+			answer := zone.resolve(question)
+
+			if answer.returnCode != test.returnCode {
+				t.Errorf("return code is %v, expected %v", answer.returnCode, test.returnCode)
+			}
+			if answer.authoritative != test.authoritative {
+				t.Errorf("authoritative is %v", answer.authoritative)
+			}
+
+			for _, section := range []struct {
+				name     string
+				records  []ResourceRecord
+				expected []string
+			}{
+				{"answers", answer.answers, test.answers},
+				{"authority", answer.authority, test.authority},
+				{"additional", answer.additional, test.additional},
+			} {
+				expected := section.expected
+				if expected == nil {
+					expected = []string{}
+				}
+
+				if got := recordStrings(section.records); !reflect.DeepEqual(got, expected) {
+					t.Errorf("%s are %q, expected %q", section.name, got, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestZoneContains(t *testing.T) {
+	zone := loadTestZone(t)
+
+	for _, name := range []string{"example.net.", "com.", "notexample.com."} {
+		qname, err := ParseDomainName(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if zone.contains(&Question{Name: *qname, Type: TYPE_A, Class: CLASS_IN}) {
+			t.Errorf("zone contains %s", name)
+		}
+	}
+}
+
+func TestNewZoneRejectsInvalidZones(t *testing.T) {
+	soa := "example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300"
+	ns := "example.com. 3600 IN NS ns1.example.com."
+
+	tests := []struct {
+		name    string
+		records []string
+		err     string
+	}{
+		{"no SOA", []string{ns}, "no SOA"},
+		{"two SOAs", []string{soa, soa, ns}, "more than one SOA"},
+		{"no NS", []string{soa}, "no NS records"},
+		{"out of zone", []string{soa, ns, "www.example.org. 60 IN A 192.0.2.1"}, "outside of zone"},
+		{"other class", []string{soa, ns, "www.example.com. 60 CH A 192.0.2.1"}, "has class CH"},
+		{"CNAME and other data", []string{soa, ns,
+			"www.example.com. 60 IN CNAME web.example.com.",
+			"www.example.com. 60 IN TXT \"text\""}, "CNAME record and other data"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewZone(parseRecords(t, test.records...))
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("error is %v, expected %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// Opens the dnstap output, unless neither -dnstap-socket nor -dnstap-file is
+// given, in which case nil is returned.
+func openDnstap(args *args) (*dns.DnstapWriter, error) {
+	if args.dnstapSocket == "" && args.dnstapFile == "" {
+		return nil, nil
+	}
+
+	identity := args.dnstapIdentity
+	if identity == "" {
+		// Without a host name the messages are sent without an identity
+		identity, _ = os.Hostname()
+	}
+
+	return dns.InitDnstapWriter(dns.DnstapOptions{
+		SocketPath: args.dnstapSocket,
+		FilePath:   args.dnstapFile,
+		Identity:   identity,
+	})
+}
+
+// Whether the dnstap output of the arguments differs from the current one.
+func dnstapChanged(args *args, current *args) bool {
+	return args.dnstapSocket != current.dnstapSocket ||
+		args.dnstapFile != current.dnstapFile ||
+		args.dnstapIdentity != current.dnstapIdentity
+}
@@ -1,109 +1,260 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"net"
-
-	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// The address the UDP and TCP listeners are bound to, unless configured
+// otherwise.
+const defaultListenAddress = "127.0.0.1:2053"
+
+// The largest UDP payload this server accepts and advertises via EDNS.
+const maxUDPPayloadSize = 4096
+
+// How long aborted requests may take to return once the grace period of a
+// shutdown is over.
+const abortTimeout = time.Second
+
+// A listener of one transport on one address.
+type server interface {
+	// Serves requests until the server is shut down or fails.
+	Serve()
+	// Stops accepting requests, while those in flight are still answered.
+	Shutdown()
+	// Waits until Serve has returned and all requests have been answered.
+	Wait()
+	// Closes the listener and its connections, even if requests are still
+	// in flight.
+	Close()
+}
+
 func main() {
 	// You can use print statements as follows for debugging, they'll be visible when running tests.
 	fmt.Println("Logs from your program will appear here!")
 
-	useForwardingResolver, resolverAddress := tryParseResolverArg()
+	args, err := parseArgs()
+	if err != nil {
+		fmt.Println("Invalid configuration:", err)
+		os.Exit(2)
+	}
 
-	var resolver dns.DnsResolver
-	var err error
-	if useForwardingResolver {
-		fmt.Println("Using forwarding resolver:", resolverAddress)
-		resolver, err = dns.InitForwardingResolver(resolverAddress)
-	} else {
-		resolver, err = dns.InitInternalResolver()
+	dnstap, err := openDnstap(args)
+	if err != nil {
+		fmt.Println("Failed to open dnstap output:", err)
+		os.Exit(1)
 	}
 
+	chain, err := buildResolver(args, nil, dnstap)
 	if err != nil {
 		fmt.Println("Failed to initialize resolver:", err)
-		return
+		os.Exit(1)
 	}
 
-	// Uncomment this block to pass the first stage
-	//
-	udpAddr, err := net.ResolveUDPAddr("udp", "127.0.0.1:2053")
-	if err != nil {
-		fmt.Println("Failed to resolve UDP address:", err)
-		return
+	var requestMetrics *metrics
+	if args.metricsListen != "" {
+		requestMetrics = newMetrics()
 	}
 
-	udpConn, err := net.ListenUDP("udp", udpAddr)
+	handler := newRequestHandler(chain, dnstap, requestMetrics)
+
+	servers, err := listen(args, handler)
 	if err != nil {
-		fmt.Println("Failed to bind to address:", err)
-		return
+		fmt.Println("Failed to start server:", err)
+		os.Exit(1)
+	}
+
+	var metricsServer *metricsServer
+	if requestMetrics != nil {
+		metricsServer, err = listenMetrics(args.metricsListen, handler)
+		if err != nil {
+			fmt.Println("Failed to start metrics server:", err)
+			os.Exit(1)
+		}
+
+		fmt.Println("Serving metrics on", args.metricsListen)
+		go metricsServer.Serve()
+	}
+
+	var running sync.WaitGroup
+	for _, s := range servers {
+		running.Add(1)
+		go func(s server) {
+			defer running.Done()
+
+			s.Serve()
+		}(s)
 	}
-	defer udpConn.Close()
 
-	buf := make([]byte, 512)
+	allStopped := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(allStopped)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
 
 	for {
-		size, source, err := udpConn.ReadFromUDP(buf)
-		if err != nil {
-			fmt.Println("Error receiving data:", err)
-			break
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				args = reload(args, handler)
+				continue
+			}
+
+			fmt.Println("Received", sig, "shutting down")
+		case <-allStopped:
+			fmt.Println("All servers stopped, shutting down")
+		}
+
+		// A second signal exits right away
+		signal.Reset(syscall.SIGINT, syscall.SIGTERM)
+		if metricsServer != nil {
+			metricsServer.Close()
 		}
+		shutdown(servers, handler, time.Duration(args.shutdownGrace)*time.Second)
+		return
+	}
+}
 
-		receivedData := string(buf[:size])
-		fmt.Printf("Received %d bytes from %s: %s\n", size, source, receivedData)
+// Stops accepting requests and gives the requests in flight the grace period
+// to be answered. Those which aren't answered by then are aborted, and the
+// connections they would be answered on are closed. Finally the resolver
+// chain and the dnstap output are closed.
+func shutdown(servers []server, handler *requestHandler, grace time.Duration) {
+	for _, s := range servers {
+		s.Shutdown()
+	}
 
-		// Print the received data as decimal bytes
-		fmt.Println("\n")
-		for i := 0; i < size; i++ {
-			fmt.Printf("%d ", buf[i])
+	drained := make(chan struct{})
+	go func() {
+		for _, s := range servers {
+			s.Wait()
 		}
-		fmt.Println("\n")
+		close(drained)
+	}()
 
-		dnsRequest, err := dns.DeserializeMessage(buf[:size])
-		if err != nil {
-			fmt.Println("Failed to deserialize request:", err)
-			response := dns.Message{
-				Header: dns.Header{
-					ID: dnsRequest.Header.ID,
-					Flags: dns.Flags{
-						QR:     true,
-						OPCODE: dnsRequest.Header.Flags.OPCODE,
-						AA:     false,
-						TC:     false,
-						RD:     dnsRequest.Header.Flags.RD,
-						RA:     false,
-						Z:      0,
-						RCODE:  dns.RCodeFormatError,
-					},
-					QDCOUNT: 0,
-					ANCOUNT: 0,
-					NSCOUNT: 0,
-					ARCOUNT: 0,
-				},
-			}
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-drained:
+		closeServers(servers)
+	case <-timer.C:
+		fmt.Println("Grace period is over, aborting the requests in flight")
+		handler.abort()
+
+		// Unblocks the responses being written to clients which don't read
+		// them, and requests waiting for a turn on their connection
+		closeServers(servers)
 
-			respondWithMessage(udpConn, source, &response)
+		abortTimer := time.NewTimer(abortTimeout)
+		defer abortTimer.Stop()
 
-			continue
+		select {
+		case <-drained:
+		case <-abortTimer.C:
+			fmt.Println("Requests are still in flight after aborting them, shutting down anyway")
 		}
+	}
+
+	handler.Close()
+
+	if handler.dnstap != nil {
+		handler.dnstap.Close()
+	}
 
-		response := resolver.Resolve(dnsRequest)
-		respondWithMessage(udpConn, source, response)
+	fmt.Println("Shut down")
+}
+
+func closeServers(servers []server) {
+	for _, s := range servers {
+		s.Close()
 	}
 }
 
-func respondWithMessage(udpConn *net.UDPConn, source *net.UDPAddr, message *dns.Message) {
-	serializedResponse, err := message.Serialize()
+// Reloads the configuration and zones, and replaces the resolver chain with
+// one built from them. If that fails, the current chain stays in effect.
+// Returns the arguments in effect afterwards.
+func reload(current *args, handler *requestHandler) *args {
+	fmt.Println("Reloading configuration")
+
+	args, err := loadArgs(current.configFile, flag.CommandLine)
 	if err != nil {
-		fmt.Println("Failed to serialize response:", err)
-		return
+		fmt.Println("Failed to reload configuration, keeping the current one:", err)
+		return current
 	}
 
-	_, err = udpConn.WriteToUDP(serializedResponse, source)
+	previous := handler.acquire()
+	defer handler.release(previous)
+
+	chain, err := buildResolver(args, previous, handler.dnstap)
 	if err != nil {
-		fmt.Println("Failed to send response:", err)
+		fmt.Println("Failed to reload resolver, keeping the current one:", err)
+		return current
+	}
+
+	handler.swap(chain)
+
+	// The listeners keep running as they are
+	if !reflect.DeepEqual(args.listenAddresses, current.listenAddresses) ||
+		!reflect.DeepEqual(args.transports, current.transports) ||
+		args.maxConcurrency != current.maxConcurrency {
+		fmt.Println("Changes to listen addresses, transports and max concurrency take effect on restart")
+		args.listenAddresses = current.listenAddresses
+		args.transports = current.transports
+		args.maxConcurrency = current.maxConcurrency
+	}
+
+	if dnstapChanged(args, current) {
+		fmt.Println("Changes to the dnstap output take effect on restart")
+		args.dnstapSocket = current.dnstapSocket
+		args.dnstapFile = current.dnstapFile
+		args.dnstapIdentity = current.dnstapIdentity
+	}
+
+	if args.metricsListen != current.metricsListen {
+		fmt.Println("Changes to the metrics listen address take effect on restart")
+		args.metricsListen = current.metricsListen
+	}
+
+	fmt.Println("Reloaded configuration")
+	return args
+}
+
+// Starts listening on every address over every transport.
+func listen(args *args, handler *requestHandler) ([]server, error) {
+	servers := make([]server, 0)
+
+	for _, address := range args.listenAddresses {
+		for _, transport := range args.transports {
+			var s server
+			var err error
+			if transport == "udp" {
+				s, err = listenUDP(address, handler, args.maxConcurrency)
+			} else {
+				s, err = listenTCP(address, handler, args.maxConcurrency)
+			}
+
+			if err != nil {
+				for _, started := range servers {
+					started.Close()
+				}
+
+				return nil, fmt.Errorf("%s on %s: %w", transport, address, err)
+			}
+
+			fmt.Println("Listening on", address, "over", transport)
+			servers = append(servers, s)
+		}
 	}
 
+	return servers, nil
 }
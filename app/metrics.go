@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// Upper bounds of the request latency histogram buckets, in seconds.
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// The labels requests are counted by.
+type requestLabels struct {
+	qtype     string
+	rcode     string
+	transport string
+}
+
+type histogram struct {
+	// The number of observations per bucket, the last one being +Inf.
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *histogram) observe(value float64) {
+	i := sort.SearchFloat64s(latencyBuckets, value)
+	h.buckets[i]++
+	h.count++
+	h.sum += value
+}
+
+// Counts the requests handled, for the /metrics endpoint. Cache and upstream
+// metrics are read from the resolver chain when scraped.
+type metrics struct {
+	mutex    sync.Mutex
+	requests map[requestLabels]uint64
+	// Request latencies and the requests being resolved, by transport.
+	latency  map[string]*histogram
+	inFlight map[string]int64
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		requests: make(map[requestLabels]uint64),
+		latency:  make(map[string]*histogram),
+		inFlight: make(map[string]int64),
+	}
+
+	// Both transports are always reported, even before their first request
+	for _, transport := range []dns.Transport{dns.TransportUDP, dns.TransportTCP} {
+		m.latency[transport.String()] = &histogram{buckets: make([]uint64, len(latencyBuckets)+1)}
+		m.inFlight[transport.String()] = 0
+	}
+
+	return m
+}
+
+func (m *metrics) requestStarted(info *dns.RequestInfo) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.inFlight[info.Transport.String()]++
+}
+
+// Records a request once it has been answered. request is nil if it could
+// not be parsed.
+func (m *metrics) requestDone(info *dns.RequestInfo, request *dns.Message, response *dns.Message) {
+	labels := requestLabels{
+		rcode:     response.ResponseCode().String(),
+		transport: info.Transport.String(),
+	}
+	if request != nil && len(request.Questions) > 0 {
+		labels.qtype = request.Questions[0].Type.String()
+	}
+
+	latency := time.Since(info.ReceivedAt).Seconds()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.inFlight[labels.transport]--
+	m.requests[labels]++
+	m.latency[labels.transport].observe(latency)
+}
+
+// Writes the metrics in the Prometheus text format, including those of the
+// cache and the upstreams of the chain.
+func (m *metrics) writeTo(w io.Writer, chain *resolverChain) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	writeHeader(w, "dns_requests_total", "counter", "Requests answered, by query type, response code and transport.")
+	requestLabelsSorted := make([]requestLabels, 0, len(m.requests))
+	for labels := range m.requests {
+		requestLabelsSorted = append(requestLabelsSorted, labels)
+	}
+	sort.Slice(requestLabelsSorted, func(i, j int) bool {
+		a, b := requestLabelsSorted[i], requestLabelsSorted[j]
+		if a.qtype != b.qtype {
+			return a.qtype < b.qtype
+		}
+		if a.rcode != b.rcode {
+			return a.rcode < b.rcode
+		}
+		return a.transport < b.transport
+	})
+	for _, labels := range requestLabelsSorted {
+		writeSample(w, "dns_requests_total", m.requests[labels],
+			"qtype", labels.qtype, "rcode", labels.rcode, "transport", labels.transport)
+	}
+
+	writeHeader(w, "dns_request_duration_seconds", "histogram", "Time from receiving a request to answering it, by transport.")
+	for _, transport := range sortedKeys(m.latency) {
+		h := m.latency[transport]
+		cumulative := uint64(0)
+		for i, count := range h.buckets {
+			cumulative += count
+			le := "+Inf"
+			if i < len(latencyBuckets) {
+				le = formatFloat(latencyBuckets[i])
+			}
+			writeSample(w, "dns_request_duration_seconds_bucket", cumulative, "transport", transport, "le", le)
+		}
+		writeSample(w, "dns_request_duration_seconds_sum", h.sum, "transport", transport)
+		writeSample(w, "dns_request_duration_seconds_count", h.count, "transport", transport)
+	}
+
+	writeHeader(w, "dns_requests_in_flight", "gauge", "Requests being resolved, by transport.")
+	for _, transport := range sortedKeys(m.inFlight) {
+		writeSample(w, "dns_requests_in_flight", m.inFlight[transport], "transport", transport)
+	}
+
+	if chain.cache != nil {
+		stats := chain.cache.Stats()
+		ratio := 0.0
+		if stats.Hits+stats.Misses > 0 {
+			ratio = float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+		}
+
+		writeHeader(w, "dns_cache_entries", "gauge", "RRsets in the cache.")
+		writeSample(w, "dns_cache_entries", stats.Size)
+		writeHeader(w, "dns_cache_hits_total", "counter", "Requests answered from the cache.")
+		writeSample(w, "dns_cache_hits_total", stats.Hits)
+		writeHeader(w, "dns_cache_misses_total", "counter", "Requests the cache could not answer.")
+		writeSample(w, "dns_cache_misses_total", stats.Misses)
+		writeHeader(w, "dns_cache_hit_ratio", "gauge", "Fraction of the requests answered from the cache.")
+		writeSample(w, "dns_cache_hit_ratio", ratio)
+	}
+
+	if chain.forwarder != nil {
+		upstreams := chain.forwarder.UpstreamStats()
+
+		writeHeader(w, "dns_upstream_rtt_seconds", "gauge", "Smoothed round trip time of the upstream, 0 until it first answers.")
+		for _, upstream := range upstreams {
+			writeSample(w, "dns_upstream_rtt_seconds", upstream.RTT.Seconds(), "upstream", upstream.Address)
+		}
+		writeHeader(w, "dns_upstream_answers_total", "counter", "Queries the upstream answered.")
+		for _, upstream := range upstreams {
+			writeSample(w, "dns_upstream_answers_total", upstream.Answers, "upstream", upstream.Address)
+		}
+		writeHeader(w, "dns_upstream_errors_total", "counter", "Queries the upstream failed to answer.")
+		for _, upstream := range upstreams {
+			writeSample(w, "dns_upstream_errors_total", upstream.Failures, "upstream", upstream.Address)
+		}
+		writeHeader(w, "dns_upstream_up", "gauge", "Whether the upstream is used, 0 while it is considered down.")
+		for _, upstream := range upstreams {
+			up := 1
+			if upstream.Down {
+				up = 0
+			}
+			writeSample(w, "dns_upstream_up", up, "upstream", upstream.Address)
+		}
+
+		writeHeader(w, "dns_upstream_queries_in_flight", "gauge", "Queries sent to upstreams which are waiting for a reply.")
+		writeSample(w, "dns_upstream_queries_in_flight", chain.forwarder.QueriesInFlight())
+	}
+}
+
+func writeHeader(w io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Writes a sample with the given label names and values, which alternate.
+func writeSample(w io.Writer, name string, value interface{}, labels ...string) {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+
+	formatted := ""
+	if len(pairs) > 0 {
+		formatted = "{" + strings.Join(pairs, ",") + "}"
+	}
+
+	if f, ok := value.(float64); ok {
+		value = formatFloat(f)
+	}
+
+	fmt.Fprintf(w, "%s%s %v\n", name, formatted, value)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Serves the /metrics endpoint over HTTP.
+type metricsServer struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+func listenMetrics(address string, handler *requestHandler) (*metricsServer, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		chain := handler.acquire()
+		defer handler.release(chain)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		buf := bufio.NewWriter(w)
+		handler.metrics.writeTo(buf, chain)
+		buf.Flush()
+	})
+
+	return &metricsServer{
+		listener: listener,
+		server: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}, nil
+}
+
+// Serves scrapes until the server is closed.
+func (s *metricsServer) Serve() {
+	err := s.server.Serve(s.listener)
+	if err != nil && err != http.ErrServerClosed {
+		fmt.Println("Metrics server failed:", err)
+	}
+}
+
+func (s *metricsServer) Close() {
+	s.server.Close()
+}
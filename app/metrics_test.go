@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func TestMetricsWritesRequests(t *testing.T) {
+	m := newMetrics()
+
+	request := &dns.Message{Questions: []dns.Question{{Type: dns.TYPE_AAAA, Class: dns.CLASS_IN}}}
+	response := dns.MakeErrorResponse(request, dns.RCodeNameError)
+
+	for i := 0; i < 2; i++ {
+		info := &dns.RequestInfo{Transport: dns.TransportUDP, ReceivedAt: time.Now().Add(-3 * time.Millisecond)}
+		m.requestStarted(info)
+		m.requestDone(info, request, response)
+	}
+
+	// Requests which could not be parsed have no query type
+	info := &dns.RequestInfo{Transport: dns.TransportTCP, ReceivedAt: time.Now()}
+	m.requestStarted(info)
+	m.requestDone(info, nil, makeFormatErrorResponse(nil))
+
+	m.requestStarted(&dns.RequestInfo{Transport: dns.TransportTCP})
+
+	var out strings.Builder
+	m.writeTo(&out, &resolverChain{})
+
+	for _, line := range []string{
+		"# TYPE dns_requests_total counter",
+		`dns_requests_total{qtype="AAAA",rcode="NXDOMAIN",transport="udp"} 2`,
+		`dns_requests_total{qtype="",rcode="FORMERR",transport="tcp"} 1`,
+		"# TYPE dns_request_duration_seconds histogram",
+		`dns_request_duration_seconds_bucket{transport="udp",le="0.0025"} 0`,
+		`dns_request_duration_seconds_bucket{transport="udp",le="0.005"} 2`,
+		`dns_request_duration_seconds_bucket{transport="udp",le="+Inf"} 2`,
+		`dns_request_duration_seconds_count{transport="udp"} 2`,
+		`dns_requests_in_flight{transport="tcp"} 1`,
+		`dns_requests_in_flight{transport="udp"} 0`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out.String())
+		}
+	}
+
+	// The chain has neither a cache nor upstreams
+	if strings.Contains(out.String(), "dns_cache") || strings.Contains(out.String(), "dns_upstream") {
+		t.Errorf("unexpected cache or upstream metrics in:\n%s", out.String())
+	}
+}
+
+func TestWriteSampleEscapesLabels(t *testing.T) {
+	var out strings.Builder
+	writeSample(&out, "test", 0.5, "label", "a\"b\\c\nd")
+
+	expected := `test{label="a\"b\\c\nd"} 0.5` + "\n"
+	if out.String() != expected {
+		t.Errorf("got %q, expected %q", out.String(), expected)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// Builds a middleware stage from the arguments.
+type middlewareFactory func(args *args) (dns.Middleware, error)
+
+// The stages which can be named in -middleware.
+var middlewareFactories = map[string]middlewareFactory{
+	"acl":       buildACLMiddleware,
+	"ratelimit": buildRateLimitMiddleware,
+	"minimal":   buildMinimalMiddleware,
+}
+
+// Builds the stages named in -middleware, in the order given.
+func buildMiddlewares(args *args) ([]dns.Middleware, error) {
+	middlewares := make([]dns.Middleware, 0, len(args.middlewares))
+	for _, name := range args.middlewares {
+		factory, ok := middlewareFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %q", name)
+		}
+
+		middleware, err := factory(args)
+		if err != nil {
+			return nil, fmt.Errorf("middleware %s: %w", name, err)
+		}
+
+		middlewares = append(middlewares, middleware)
+	}
+
+	return middlewares, nil
+}
+
+func buildACLMiddleware(args *args) (dns.Middleware, error) {
+	allow, err := dns.ParseNetworks(args.aclAllow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -acl-allow: %w", err)
+	}
+
+	deny, err := dns.ParseNetworks(args.aclDeny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -acl-deny: %w", err)
+	}
+
+	return func(next dns.ContextResolver) (dns.ContextResolver, error) {
+		return dns.InitACLResolver(next, dns.ACLOptions{Allow: allow, Deny: deny})
+	}, nil
+}
+
+func buildRateLimitMiddleware(args *args) (dns.Middleware, error) {
+	if args.rateLimit <= 0 {
+		return nil, fmt.Errorf("rate limit must be positive, got %v", args.rateLimit)
+	}
+
+	if args.rateBurst < 1 {
+		return nil, fmt.Errorf("rate limit burst must be at least 1, got %d", args.rateBurst)
+	}
+
+	options := dns.RateLimitOptions{
+		Rate:  args.rateLimit,
+		Burst: args.rateBurst,
+	}
+
+	return func(next dns.ContextResolver) (dns.ContextResolver, error) {
+		return dns.InitRateLimitResolver(next, options)
+	}, nil
+}
+
+func buildMinimalMiddleware(args *args) (dns.Middleware, error) {
+	return dns.MinimalResponses, nil
+}
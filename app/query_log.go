@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// Writes one structured entry per request to the configured sinks: a JSON
+// file, which is rotated once it grows too large, and stdout.
+type queryLogger struct {
+	logger *slog.Logger
+	// The fraction of requests logged, between 0 and 1.
+	sampleRate float64
+	// The file written to, nil if none. The logger closes it, unless the
+	// logger replacing it has taken it over.
+	file *rotatingFile
+}
+
+// Opens the sinks of the query log. Returns nil if requests aren't logged.
+// If the logger replaces a previous one writing to the same file, the file
+// is taken over rather than opened a second time, which would rotate it
+// twice.
+func newQueryLogger(args *args, previous *queryLogger) (*queryLogger, error) {
+	if !args.logRequests {
+		return nil, nil
+	}
+
+	l := &queryLogger{sampleRate: args.logSampleRate}
+	handlers := make([]slog.Handler, 0)
+
+	if args.logFile != "" {
+		maxSize := int64(args.logMaxSize) * 1024 * 1024
+		maxBackups := int(args.logMaxBackups)
+
+		if previous != nil && previous.file != nil && previous.file.path == args.logFile {
+			l.file = previous.file
+			l.file.setLimits(maxSize, maxBackups)
+		} else {
+			file, err := openRotatingFile(args.logFile, maxSize, maxBackups)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open query log: %w", err)
+			}
+
+			l.file = file
+		}
+
+		handlers = append(handlers, slog.NewJSONHandler(l.file, nil))
+	}
+
+	if args.logStdout {
+		handlers = append(handlers, slog.NewTextHandler(os.Stdout, nil))
+	}
+
+	if len(handlers) == 0 {
+		return nil, nil
+	}
+
+	// The previous logger keeps writing to the file until its requests are
+	// done, but leaves closing it to this one
+	if previous != nil && previous.file == l.file {
+		previous.file = nil
+	}
+
+	l.logger = slog.New(multiHandler(handlers))
+	return l, nil
+}
+
+// Logs the request and the response sent to it. request is nil if the
+// request could not be parsed.
+func (l *queryLogger) log(info *dns.RequestInfo, request *dns.Message, response *dns.Message) {
+	if l.sampleRate < 1 && rand.Float64() >= l.sampleRate {
+		return
+	}
+
+	attrs := []slog.Attr{
+		slog.String("client", fmt.Sprint(info.ClientAddr)),
+		slog.String("transport", info.Transport.String()),
+	}
+
+	if request != nil && len(request.Questions) > 0 {
+		question := &request.Questions[0]
+		attrs = append(attrs,
+			slog.String("qname", question.Name.String()),
+			slog.String("qtype", question.Type.String()))
+	}
+
+	attrs = append(attrs,
+		slog.String("rcode", response.ResponseCode().String()),
+		slog.Int("answers", len(response.Answers)),
+		slog.Float64("latency_ms", float64(time.Since(info.ReceivedAt).Microseconds())/1000),
+		slog.Bool("cache_hit", info.CacheHit))
+
+	if info.Upstream != "" {
+		attrs = append(attrs, slog.String("upstream", info.Upstream))
+	}
+
+	l.logger.LogAttrs(context.Background(), slog.LevelInfo, "query", attrs...)
+}
+
+func (l *queryLogger) Close() {
+	if l.file != nil {
+		l.file.Close()
+	}
+}
+
+// Passes every record on to all of the handlers.
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range m {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, record slog.Record) error {
+	var firstErr error
+	for _, handler := range m {
+		if !handler.Enabled(ctx, record.Level) {
+			continue
+		}
+
+		err := handler.Handle(ctx, record.Clone())
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(multiHandler, 0, len(m))
+	for _, handler := range m {
+		handlers = append(handlers, handler.WithAttrs(attrs))
+	}
+
+	return handlers
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	handlers := make(multiHandler, 0, len(m))
+	for _, handler := range m {
+		handlers = append(handlers, handler.WithGroup(name))
+	}
+
+	return handlers
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func logTestQuery(l *queryLogger) {
+	request := &dns.Message{Questions: []dns.Question{{Type: dns.TYPE_A, Class: dns.CLASS_IN}}}
+	info := &dns.RequestInfo{Transport: dns.TransportUDP, ReceivedAt: time.Now()}
+	l.log(info, request, dns.MakeErrorResponse(request, dns.RCodeNoError))
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Count(string(data), "\n")
+}
+
+func TestQueryLoggerTakesOverFileOnReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.json")
+	a := &args{logRequests: true, logFile: path, logMaxSize: 100, logMaxBackups: 1, logSampleRate: 1}
+
+	previous, err := newQueryLogger(a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := previous.file
+
+	a.logMaxBackups = 3
+	l, err := newQueryLogger(a, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if l.file != file || previous.file != nil {
+		t.Fatal("the file wasn't taken over")
+	}
+	if l.file.maxBackups != 3 {
+		t.Errorf("file keeps %d backups, expected the reloaded 3", l.file.maxBackups)
+	}
+
+	// Requests of the previous chain still in flight are logged to the same
+	// file, which stays open once the previous logger is closed
+	logTestQuery(previous)
+	previous.Close()
+	logTestQuery(l)
+
+	if lines := countLines(t, path); lines != 2 {
+		t.Errorf("query log has %d lines, expected 2", lines)
+	}
+}
+
+func TestQueryLoggerOpensChangedFileOnReload(t *testing.T) {
+	dir := t.TempDir()
+	a := &args{logRequests: true, logFile: filepath.Join(dir, "old.json"), logSampleRate: 1}
+
+	previous, err := newQueryLogger(a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer previous.Close()
+
+	a.logFile = filepath.Join(dir, "new.json")
+	l, err := newQueryLogger(a, previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if l.file == previous.file || previous.file == nil {
+		t.Fatal("the file of the previous logger was taken over")
+	}
+
+	logTestQuery(previous)
+	logTestQuery(l)
+
+	for _, name := range []string{"old.json", "new.json"} {
+		if lines := countLines(t, filepath.Join(dir, name)); lines != 1 {
+			t.Errorf("%s has %d lines, expected 1", name, lines)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// A resolver chain built from the arguments, together with the resources it
+// holds.
+type resolverChain struct {
+	resolver dns.ContextResolver
+	// The cache of the chain, nil if caching is disabled.
+	cache *dns.CachingResolver
+	// The forwarding resolver of the chain, nil if requests aren't forwarded.
+	forwarder *dns.ForwardingResolver
+	// The query log requests are written to, nil if they aren't logged.
+	queryLog *queryLogger
+	// Release the resources of the chain, e.g. sockets.
+	closers []func()
+
+	// The number of requests using the chain, and whether it has been
+	// replaced. Both are guarded by the mutex of the requestHandler.
+	users   int
+	retired bool
+}
+
+// Releases the resources of the chain. It must not be used anymore.
+func (c *resolverChain) Close() {
+	for _, close := range c.closers {
+		close()
+	}
+}
+
+// Builds the resolver answering the requests of clients:
+//
+//   - with -zone, zones are answered authoritatively, and other names are
+//     forwarded or resolved if -resolver or -recursive is given as well, or
+//     refused otherwise,
+//   - with -resolver, requests are forwarded to the upstream resolvers,
+//     through the cache,
+//   - with -recursive, requests are resolved iteratively starting from the
+//     root servers, through the cache,
+//   - otherwise the internal resolver answers every question.
+//
+// Requests pass through the -middleware stages before they reach it. If the
+// chain replaces a previous one, the cache of that chain is taken over. The
+// query log is opened along with the chain, so that it is replaced with it,
+// but keeps writing to the file of the previous chain if it is the same.
+// Queries forwarded to upstreams are written to dnstap, unless it is nil.
+func buildResolver(args *args, previous *resolverChain, dnstap *dns.DnstapWriter) (*resolverChain, error) {
+	c := &resolverChain{}
+	resolver, err := c.build(args, previous, dnstap)
+	if err == nil {
+		var previousLog *queryLogger
+		if previous != nil {
+			previousLog = previous.queryLog
+		}
+		c.queryLog, err = newQueryLogger(args, previousLog)
+	}
+	if err != nil {
+		// Release what was built before failing
+		c.Close()
+		return nil, err
+	}
+
+	if c.queryLog != nil {
+		c.closers = append(c.closers, c.queryLog.Close)
+	}
+
+	c.resolver = resolver
+	return c, nil
+}
+
+func (c *resolverChain) build(args *args, previous *resolverChain, dnstap *dns.DnstapWriter) (dns.ContextResolver, error) {
+	var resolver dns.DnsResolver
+	var err error
+
+	if args.useForwardingResolver() {
+		resolver, err = c.buildForwardingResolver(args, previous, dnstap)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if args.recursive {
+		resolver, err = buildRecursiveResolver(args)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resolver != nil {
+		resolver, err = c.withCache(resolver, args, previous)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(args.zoneFiles) > 0 {
+		zones := make([]*dns.Zone, 0, len(args.zoneFiles))
+		for _, zoneFile := range args.zoneFiles {
+			zone, err := dns.LoadZone(zoneFile, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load zone: %w", err)
+			}
+
+			fmt.Println("Serving zone", zone.Origin.String(), "from", zoneFile)
+			zones = append(zones, zone)
+		}
+
+		resolver, err = dns.InitAuthoritativeResolver(zones, resolver)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resolver == nil {
+		resolver, err = dns.InitInternalResolver()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	middlewares, err := buildMiddlewares(args)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := dns.Chain(dns.AdaptResolver(resolver), middlewares...)
+	if err != nil {
+		return nil, err
+	}
+
+	return dns.InitEDNSResolver(chain, maxUDPPayloadSize)
+}
+
+func (c *resolverChain) buildForwardingResolver(args *args, previous *resolverChain, dnstap *dns.DnstapWriter) (dns.DnsResolver, error) {
+	policy, err := dns.ParseUpstreamPolicy(args.resolverPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -resolver-policy: %w", err)
+	}
+
+	fmt.Println("Using forwarding resolver:", args.resolverAddresses, policy)
+	resolver, err := dns.InitForwardingResolver(args.resolverAddresses, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	if dnstap != nil {
+		resolver.SetDnstap(dnstap)
+	}
+
+	if previous != nil && previous.forwarder != nil {
+		resolver.TakeOverUpstreams(previous.forwarder)
+	}
+
+	c.forwarder = resolver
+	c.closers = append(c.closers, resolver.Close)
+	return resolver, nil
+}
+
+func buildRecursiveResolver(args *args) (dns.DnsResolver, error) {
+	fmt.Println("Using recursive resolver")
+	resolver, err := dns.InitRecursiveResolver(dns.RecursiveOptions{
+		RootHints: args.rootHints,
+		Port:      args.recursivePort,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resolver, nil
+}
+
+// Wraps the resolver with a cache, unless caching is disabled. The cached
+// records of the previous chain are kept if its cache has the same size.
+func (c *resolverChain) withCache(resolver dns.DnsResolver, args *args, previous *resolverChain) (dns.DnsResolver, error) {
+	if args.cacheSize == 0 {
+		return resolver, nil
+	}
+
+	cachingResolver, err := dns.InitCachingResolver(resolver, dns.CacheOptions{
+		MaxEntries: args.cacheSize,
+		MinTTL:     uint32(args.cacheMinTTL),
+		MaxTTL:     uint32(args.cacheMaxTTL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize cache: %w", err)
+	}
+
+	if previous != nil && previous.cache != nil {
+		err = cachingResolver.TakeOverCache(previous.cache)
+		if err != nil {
+			fmt.Println("Starting with an empty cache:", err)
+		}
+	}
+
+	c.cache = cachingResolver
+	return cachingResolver, nil
+}
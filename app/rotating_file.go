@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// A file which is appended to, and rotated once it would grow beyond its
+// maximum size: the file is renamed to <path>.1, older backups are shifted
+// to <path>.2 and so on, and the oldest one is removed.
+type rotatingFile struct {
+	mutex sync.Mutex
+	path  string
+	// The size at which the file is rotated, 0 to never rotate it.
+	maxSize int64
+	// The number of rotated files kept.
+	maxBackups int
+	// The size at which the file is rotated next: maxSize, unless rotating
+	// failed, in which case it is only tried again once the file has grown
+	// by maxSize once more, rather than failing every write.
+	rotateAt int64
+
+	// The file written to, nil if it was closed, or couldn't be reopened
+	// after rotating it, in which case the next write tries again.
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		rotateAt:   maxSize,
+	}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Changes the size at which the file is rotated and the number of rotated
+// files kept, taking effect with the next write.
+func (f *rotatingFile) setLimits(maxSize int64, maxBackups int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.maxSize = maxSize
+	f.maxBackups = maxBackups
+	f.rotateAt = maxSize
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.closed {
+		return 0, fmt.Errorf("%s is closed", f.path)
+	}
+
+	var rotateErr error
+	// A single write larger than the maximum still goes into a file of its own
+	if f.file != nil && f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.rotateAt {
+		rotateErr = f.rotate()
+		if rotateErr == nil {
+			f.rotateAt = f.maxSize
+		} else {
+			fmt.Println("Failed to rotate", f.path+":", rotateErr)
+			rotateErr = fmt.Errorf("failed to rotate %s: %w", f.path, rotateErr)
+		}
+	}
+
+	// Whatever is left of the file after a failed rotation is appended to,
+	// so that the log goes on
+	if f.file == nil {
+		err := f.open()
+		if err != nil {
+			if rotateErr != nil {
+				return 0, rotateErr
+			}
+			return 0, fmt.Errorf("failed to reopen %s: %w", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if rotateErr != nil {
+		f.rotateAt = f.size + f.maxSize
+		if err == nil {
+			err = rotateErr
+		}
+	}
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return err
+	}
+
+	if f.maxBackups == 0 {
+		err = os.Remove(f.path)
+	} else {
+		for i := f.maxBackups - 1; i > 0; i-- {
+			err = os.Rename(f.backupPath(i), f.backupPath(i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		err = os.Rename(f.path, f.backupPath(1))
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return f.open()
+}
+
+func (f *rotatingFile) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+func (f *rotatingFile) Close() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.closed = true
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
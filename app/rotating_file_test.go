@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFileKeepsBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.json")
+
+	file, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := file.Write([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+
+	for name, content := range expected {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != content {
+			t.Errorf("%s contains %q, expected %q", filepath.Base(name), data, content)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected no third backup, got %v", err)
+	}
+}
+
+func TestRotatingFileAppendsToExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.json")
+	err := os.WriteFile(path, []byte("12345678\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := openRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	_, err = file.Write([]byte("next\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "12345678\n" {
+		t.Errorf("backup contains %q", data)
+	}
+}
+
+func TestRotatingFileKeepsWritingWhenRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.json")
+
+	// The first backup can't be replaced, as a directory is in its way
+	blocker := filepath.Join(path+".1", "blocker")
+	err := os.MkdirAll(blocker, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := openRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	write := func(line string, fails bool) {
+		t.Helper()
+
+		n, err := file.Write([]byte(line))
+		if n != len(line) {
+			t.Fatalf("wrote %d of %d bytes: %v", n, len(line), err)
+		}
+		if (err != nil) != fails {
+			t.Fatalf("error is %v, expected failure %v", err, fails)
+		}
+	}
+
+	write("first\n", false)
+	write("second\n", true)
+
+	// Rotation isn't tried again until the file has grown by the maximum
+	write("third\n", false)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "first\nsecond\nthird\n" {
+		t.Errorf("file contains %q", data)
+	}
+
+	// Once the way is clear, the file is rotated again
+	err = os.RemoveAll(path + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("fourth\n", false)
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "fourth\n" {
+		t.Errorf("file contains %q after rotating", data)
+	}
+}
+
+func TestRotatingFileReopensAfterFailingToOpen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	err := os.Mkdir(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "queries.json")
+
+	file, err := openRotatingFile(path, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	_, err = file.Write([]byte("first\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is removed by the rotation, and can't be created again while
+	// its directory is gone
+	err = os.RemoveAll(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = file.Write([]byte("second\n"))
+	if err == nil {
+		t.Fatal("expected writing without a directory to fail")
+	}
+
+	err = os.Mkdir(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = file.Write([]byte("third\n"))
+	if err != nil {
+		t.Fatalf("file wasn't reopened: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "third\n" {
+		t.Errorf("file contains %q", data)
+	}
+}
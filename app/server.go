@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// How long a request may take to resolve before it is answered with
+// SERVFAIL.
+const requestTimeout = 10 * time.Second
+
+// Resolves the requests received by the servers of every transport. The
+// resolver chain can be replaced while requests are being resolved: requests
+// in flight finish with the chain they started with, which is closed once
+// the last of them is done.
+type requestHandler struct {
+	mutex sync.Mutex
+	chain *resolverChain
+
+	// The context of all resolutions, canceled to abort them.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Receives the requests and responses exchanged with clients, nil if
+	// they aren't logged via dnstap.
+	dnstap *dns.DnstapWriter
+	// Counts the requests, nil if metrics aren't exposed.
+	metrics *metrics
+}
+
+func newRequestHandler(chain *resolverChain, dnstap *dns.DnstapWriter, metrics *metrics) *requestHandler {
+	h := &requestHandler{chain: chain, dnstap: dnstap, metrics: metrics}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	return h
+}
+
+// Aborts the resolutions in progress, which are answered with SERVFAIL.
+func (h *requestHandler) abort() {
+	h.cancel()
+}
+
+// Closes the resolver chain once the requests using it are done. No more
+// requests may be handled.
+func (h *requestHandler) Close() {
+	h.mutex.Lock()
+	h.chain.retired = true
+	unused := h.chain.users == 0
+	h.mutex.Unlock()
+
+	if unused {
+		h.chain.Close()
+	}
+}
+
+// Replaces the resolver chain for all requests received from now on.
+func (h *requestHandler) swap(chain *resolverChain) {
+	h.mutex.Lock()
+	previous := h.chain
+	h.chain = chain
+	previous.retired = true
+	unused := previous.users == 0
+	h.mutex.Unlock()
+
+	if unused {
+		previous.Close()
+	}
+}
+
+// Returns the current resolver chain, which stays open until it is released.
+func (h *requestHandler) acquire() *resolverChain {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.chain.users++
+	return h.chain
+}
+
+func (h *requestHandler) release(chain *resolverChain) {
+	h.mutex.Lock()
+	chain.users--
+	unused := chain.retired && chain.users == 0
+	h.mutex.Unlock()
+
+	if unused {
+		chain.Close()
+	}
+}
+
+// Resolves a single request received over any transport, and writes it to
+// the query log, dnstap and the metrics. Returns the parsed request, which is
+// nil if it could not be parsed, and the response to send. Once the request
+// is parsed, info.MaxResponseSize is set to the largest response the client
+// is able to receive.
+func (h *requestHandler) handle(data []byte, info *dns.RequestInfo) (*dns.Message, *dns.Message) {
+	chain := h.acquire()
+	defer h.release(chain)
+
+	if h.metrics != nil {
+		h.metrics.requestStarted(info)
+	}
+
+	if h.dnstap != nil {
+		h.dnstap.Write(&dns.DnstapMessage{
+			Type:         dns.DnstapClientQuery,
+			Transport:    info.Transport,
+			QueryAddr:    info.ClientAddr,
+			ResponseAddr: info.ServerAddr,
+			QueryTime:    info.ReceivedAt,
+			Query:        data,
+		})
+	}
+
+	request, response := h.resolve(chain, data, info)
+
+	if chain.queryLog != nil {
+		chain.queryLog.log(info, request, response)
+	}
+
+	if h.metrics != nil {
+		h.metrics.requestDone(info, request, response)
+	}
+
+	if h.dnstap != nil {
+		h.tapResponse(info, response)
+	}
+
+	return request, response
+}
+
+// Writes the response to dnstap as it is sent to the client, i.e. truncated
+// to fit if need be.
+func (h *requestHandler) tapResponse(info *dns.RequestInfo, response *dns.Message) {
+	serialized, err := response.SerializeTruncated(info.MaxResponseSize)
+	if err != nil {
+		// The server reports the failure when sending the response
+		return
+	}
+
+	h.dnstap.Write(&dns.DnstapMessage{
+		Type:         dns.DnstapClientResponse,
+		Transport:    info.Transport,
+		QueryAddr:    info.ClientAddr,
+		ResponseAddr: info.ServerAddr,
+		ResponseTime: time.Now(),
+		Response:     serialized,
+	})
+}
+
+func (h *requestHandler) resolve(chain *resolverChain, data []byte, info *dns.RequestInfo) (*dns.Message, *dns.Message) {
+	dnsRequest, err := dns.DeserializeMessage(data)
+	if err != nil {
+		fmt.Println("Failed to deserialize request:", err)
+		return nil, makeFormatErrorResponse(data)
+	}
+
+	if info.Transport == dns.TransportUDP {
+		info.MaxResponseSize = dns.MaxUDPPayloadSize(dnsRequest, maxUDPPayloadSize)
+	}
+
+	ctx, cancel := context.WithTimeout(h.ctx, requestTimeout)
+	defer cancel()
+
+	response, err := chain.resolver.ResolveContext(ctx, info, dnsRequest)
+	if err != nil {
+		fmt.Println("Failed to resolve request:", err)
+		response = dns.MakeErrorResponse(dnsRequest, dns.RCodeServerFailure)
+	}
+
+	return dnsRequest, response
+}
+
+// Builds the response to a request which could not be parsed, echoing what
+// can be read from its header.
+func makeFormatErrorResponse(data []byte) *dns.Message {
+	response := &dns.Message{
+		Header: dns.Header{
+			Flags: dns.Flags{
+				QR:    true,
+				RCODE: dns.RCodeFormatError,
+			},
+		},
+	}
+
+	if len(data) >= 2 {
+		response.Header.ID = binary.BigEndian.Uint16(data)
+	}
+
+	if len(data) >= 3 {
+		// OPCODE and RD live in the third byte of the header
+		response.Header.Flags.OPCODE = dns.OpCode((data[2] >> 3) & 0x0F)
+		response.Header.Flags.RD = data[2]&0x01 == 1
+	}
+
+	return response
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// How long a connection may stay idle before the server closes it
+// (RFC 7766 section 6.2.3).
+const tcpIdleTimeout = 10 * time.Second
+
+// How long the client may take to accept a response. A client which stops
+// reading has its connection closed.
+const tcpWriteTimeout = 10 * time.Second
+
+// Serves requests over TCP, where every message is prefixed with its length
+// (RFC 7766). A connection may carry any number of requests, and requests may
+// be pipelined: they are resolved concurrently and answered in the order
+// their responses become ready. At most maxConcurrency requests of a
+// connection are resolved at the same time; once that many are in flight the
+// server stops reading from it until one of them is answered.
+type tcpServer struct {
+	listener       *net.TCPListener
+	handler        *requestHandler
+	maxConcurrency int
+
+	mutex sync.Mutex
+	// The open connections, which stop reading on Shutdown and are closed
+	// on Close.
+	conns        map[*net.TCPConn]struct{}
+	connsServing sync.WaitGroup
+	shuttingDown atomic.Bool
+	// Closed once Serve returns.
+	stopped chan struct{}
+}
+
+func listenTCP(address string, handler *requestHandler, maxConcurrency int) (*tcpServer, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.ListenTCP("tcp", tcpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tcpServer{
+		listener:       listener,
+		handler:        handler,
+		maxConcurrency: maxConcurrency,
+		conns:          make(map[*net.TCPConn]struct{}),
+		stopped:        make(chan struct{}),
+	}, nil
+}
+
+// Accepts connections until the server is shut down or the listener is
+// closed.
+func (s *tcpServer) Serve() {
+	defer close(s.stopped)
+
+	for {
+		conn, err := s.listener.AcceptTCP()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				fmt.Println("Error accepting connection:", err)
+			}
+			break
+		}
+
+		s.addConn(conn)
+		go s.serveConn(conn)
+	}
+}
+
+func (s *tcpServer) addConn(conn *net.TCPConn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.conns[conn] = struct{}{}
+	s.connsServing.Add(1)
+}
+
+func (s *tcpServer) removeConn(conn *net.TCPConn) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.conns, conn)
+	s.connsServing.Done()
+}
+
+func (s *tcpServer) serveConn(conn *net.TCPConn) {
+	defer s.removeConn(conn)
+	defer conn.Close()
+
+	// Responses of pipelined requests must not interleave
+	var writeMutex sync.Mutex
+	var pending sync.WaitGroup
+	defer pending.Wait()
+
+	// Holds a token for every request of the connection being resolved
+	slots := make(chan struct{}, s.maxConcurrency)
+
+	for {
+		slots <- struct{}{}
+
+		// Connections added after Shutdown has stopped the reads of the
+		// others must not start reading
+		if s.shuttingDown.Load() {
+			return
+		}
+
+		err := conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		if err != nil {
+			fmt.Println("Failed to set read deadline:", err)
+			return
+		}
+
+		data, err := dns.ReadTCPMessage(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) && !errors.Is(err, net.ErrClosed) {
+				fmt.Println("Error receiving data:", err)
+			}
+			return
+		}
+
+		pending.Add(1)
+		go func() {
+			defer pending.Done()
+			defer func() { <-slots }()
+
+			info := &dns.RequestInfo{
+				ClientAddr:      conn.RemoteAddr(),
+				ServerAddr:      conn.LocalAddr(),
+				Transport:       dns.TransportTCP,
+				MaxResponseSize: dns.MAX_TCP_MESSAGE_SIZE,
+				ReceivedAt:      time.Now(),
+			}
+
+			_, response := s.handler.handle(data, info)
+
+			serializedResponse, err := response.Serialize()
+			if err != nil {
+				fmt.Println("Failed to serialize response:", err)
+				return
+			}
+
+			writeMutex.Lock()
+			defer writeMutex.Unlock()
+
+			err = conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+			if err == nil {
+				err = dns.WriteTCPMessage(conn, serializedResponse)
+			}
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					fmt.Println("Failed to send response:", err)
+				}
+				// The connection is unusable once a write failed part way,
+				// and a client which doesn't read mustn't hold on to it
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// Stops accepting connections and reading requests from the open ones. The
+// requests being resolved are still answered, after which the connections
+// are closed.
+func (s *tcpServer) Shutdown() {
+	s.shuttingDown.Store(true)
+	s.listener.Close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for conn := range s.conns {
+		// Interrupts the read in progress, and fails all following ones,
+		// while responses can still be written
+		err := conn.CloseRead()
+		if err != nil {
+			fmt.Println("Failed to stop reading requests:", err)
+		}
+	}
+}
+
+// Waits until Serve has returned and every connection has been closed.
+func (s *tcpServer) Wait() {
+	<-s.stopped
+
+	// No connections are added once Serve has returned
+	s.connsServing.Wait()
+}
+
+// Closes the listener and every open connection, whether or not its
+// requests have been answered.
+func (s *tcpServer) Close() {
+	s.listener.Close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
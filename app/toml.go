@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A value of a TOML document, with the line it was found on for error
+// messages. Only the types the configuration file needs are supported:
+// strings, integers, floats, booleans and arrays of them.
+type tomlValue struct {
+	line int
+	// One of string, int64, float64, bool or []tomlValue.
+	value interface{}
+}
+
+// The keys of a TOML table, in the order they were found in.
+type tomlTable struct {
+	name   string
+	line   int
+	keys   []string
+	values map[string]*tomlValue
+}
+
+// Parses a TOML document consisting of top-level keys, followed by [tables]
+// of keys. Dotted keys, inline tables and arrays of tables are not
+// supported. The top-level keys are returned as a table named "".
+func parseTOML(content string) ([]*tomlTable, error) {
+	p := &tomlParser{lines: strings.Split(content, "\n")}
+	current := &tomlTable{name: "", values: make(map[string]*tomlValue)}
+	tables := []*tomlTable{current}
+	seen := map[string]bool{"": true}
+
+	for p.next() {
+		line := strings.TrimSpace(stripTOMLComment(p.text))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, p.errorf("invalid table header %s", line)
+			}
+
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if !isBareTOMLKey(name) {
+				return nil, p.errorf("invalid table name %q", name)
+			}
+
+			if seen[name] {
+				return nil, p.errorf("table [%s] defined twice", name)
+			}
+			seen[name] = true
+
+			current = &tomlTable{name: name, line: p.lineNumber, values: make(map[string]*tomlValue)}
+			tables = append(tables, current)
+			continue
+		}
+
+		key, rest, found := strings.Cut(p.text, "=")
+		if !found {
+			return nil, p.errorf("expected key = value")
+		}
+
+		key = strings.TrimSpace(key)
+		if !isBareTOMLKey(key) {
+			return nil, p.errorf("invalid key %q", key)
+		}
+
+		if _, ok := current.values[key]; ok {
+			return nil, p.errorf("key %q defined twice", key)
+		}
+
+		p.rest = rest
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.TrimSpace(stripTOMLComment(p.rest)) != "" {
+			return nil, p.errorf("unexpected %q after value of %q", strings.TrimSpace(p.rest), key)
+		}
+
+		current.keys = append(current.keys, key)
+		current.values[key] = value
+	}
+
+	return tables, nil
+}
+
+type tomlParser struct {
+	lines      []string
+	lineNumber int
+	// The current line...
+	text string
+	// ...and what remains to be parsed of it.
+	rest string
+}
+
+// Moves on to the next line, returning false at the end of the document.
+func (p *tomlParser) next() bool {
+	if p.lineNumber >= len(p.lines) {
+		return false
+	}
+
+	p.text = p.lines[p.lineNumber]
+	p.rest = p.text
+	p.lineNumber++
+	return true
+}
+
+func (p *tomlParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.lineNumber, fmt.Sprintf(format, a...))
+}
+
+// Skips whitespace and comments, continuing on the next lines if
+// multiline is set, as inside of arrays.
+func (p *tomlParser) skipSpace(multiline bool) {
+	for {
+		p.rest = strings.TrimLeft(p.rest, " \t\r")
+		if strings.HasPrefix(p.rest, "#") {
+			p.rest = ""
+		}
+
+		if p.rest != "" || !multiline || !p.next() {
+			return
+		}
+	}
+}
+
+func (p *tomlParser) parseValue() (*tomlValue, error) {
+	p.skipSpace(false)
+	line := p.lineNumber
+
+	switch {
+	case p.rest == "":
+		return nil, p.errorf("missing value")
+	case p.rest[0] == '"':
+		s, err := p.parseBasicString()
+		return &tomlValue{line: line, value: s}, err
+	case p.rest[0] == '\'':
+		end := strings.IndexByte(p.rest[1:], '\'')
+		if end < 0 {
+			return nil, p.errorf("unterminated string")
+		}
+
+		s := p.rest[1 : end+1]
+		p.rest = p.rest[end+2:]
+		return &tomlValue{line: line, value: s}, nil
+	case p.rest[0] == '[':
+		return p.parseArray()
+	}
+
+	// A bare value extends to the next delimiter
+	end := strings.IndexAny(p.rest, " \t\r#,]")
+	if end < 0 {
+		end = len(p.rest)
+	}
+	token := p.rest[:end]
+	p.rest = p.rest[end:]
+
+	switch token {
+	case "true":
+		return &tomlValue{line: line, value: true}, nil
+	case "false":
+		return &tomlValue{line: line, value: false}, nil
+	}
+
+	digits := strings.ReplaceAll(token, "_", "")
+	if i, err := strconv.ParseInt(digits, 10, 64); err == nil {
+		return &tomlValue{line: line, value: i}, nil
+	}
+
+	if f, err := strconv.ParseFloat(digits, 64); err == nil {
+		return &tomlValue{line: line, value: f}, nil
+	}
+
+	return nil, p.errorf("invalid value %q, strings must be quoted", token)
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	var builder strings.Builder
+
+	for i := 1; i < len(p.rest); i++ {
+		c := p.rest[i]
+		switch c {
+		case '"':
+			p.rest = p.rest[i+1:]
+			return builder.String(), nil
+		case '\\':
+			i++
+			if i == len(p.rest) {
+				return "", p.errorf("unterminated string")
+			}
+
+			switch p.rest[i] {
+			case '"', '\\':
+				builder.WriteByte(p.rest[i])
+			case 'n':
+				builder.WriteByte('\n')
+			case 't':
+				builder.WriteByte('\t')
+			default:
+				return "", p.errorf("unsupported escape \\%c", p.rest[i])
+			}
+		default:
+			builder.WriteByte(c)
+		}
+	}
+
+	return "", p.errorf("unterminated string")
+}
+
+// Parses an array, which may span several lines and end with a comma.
+func (p *tomlParser) parseArray() (*tomlValue, error) {
+	line := p.lineNumber
+	elements := make([]tomlValue, 0)
+	p.rest = p.rest[1:]
+
+	for {
+		p.skipSpace(true)
+		if p.rest == "" {
+			return nil, fmt.Errorf("line %d: unterminated array", line)
+		}
+
+		if p.rest[0] == ']' {
+			p.rest = p.rest[1:]
+			return &tomlValue{line: line, value: elements}, nil
+		}
+
+		element, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, *element)
+
+		p.skipSpace(true)
+		if strings.HasPrefix(p.rest, ",") {
+			p.rest = p.rest[1:]
+		} else if !strings.HasPrefix(p.rest, "]") {
+			return nil, p.errorf("expected , or ] in array")
+		}
+	}
+}
+
+// Removes a comment from the end of a line, unless the # is quoted.
+func stripTOMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+func isBareTOMLKey(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	for _, c := range key {
+		isLetter := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		isDigit := c >= '0' && c <= '9'
+		if !isLetter && !isDigit && c != '_' && c != '-' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Returns the values of a table as plain Go values, with arrays as slices of
+// them, for comparing.
+func tomlTableValues(table *tomlTable) map[string]interface{} {
+	values := make(map[string]interface{}, len(table.keys))
+	for _, key := range table.keys {
+		values[key] = plainTOMLValue(table.values[key].value)
+	}
+
+	return values
+}
+
+func plainTOMLValue(value interface{}) interface{} {
+	elements, ok := value.([]tomlValue)
+	if !ok {
+		return value
+	}
+
+	plain := make([]interface{}, 0, len(elements))
+	for _, element := range elements {
+		plain = append(plain, plainTOMLValue(element.value))
+	}
+
+	return plain
+}
+
+func TestParseTOML(t *testing.T) {
+	content := strings.Join([]string{
+		"# A comment before any table",
+		"",
+		"[server]",
+		`listen = ["127.0.0.1:2053", "[::1]:2053"] # trailing comment`,
+		"max_concurrency = 1_024",
+		"",
+		"[resolver]   # a comment after the header",
+		"upstreams = [",
+		`  "192.0.2.53:53",  # the first`,
+		"  # a line of its own",
+		`  "198.51.100.53:53",`,
+		"]",
+		"empty = []",
+		"nested = [[1, 2], [true]]",
+		"",
+		"[quoting]",
+		`hash = "not # a comment"`,
+		`escapes = "a \"quoted\" \\ word\n\tindented"`,
+		`literal = 'C:\path # too'`,
+		"rate = 0.5",
+		"negative = -3",
+		"enabled = false",
+	}, "\n")
+
+	tables, err := parseTOML(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		name   string
+		values map[string]interface{}
+	}{
+		{"", map[string]interface{}{}},
+		{"server", map[string]interface{}{
+			"listen":          []interface{}{"127.0.0.1:2053", "[::1]:2053"},
+			"max_concurrency": int64(1024),
+		}},
+		{"resolver", map[string]interface{}{
+			"upstreams": []interface{}{"192.0.2.53:53", "198.51.100.53:53"},
+			"empty":     []interface{}{},
+			"nested":    []interface{}{[]interface{}{int64(1), int64(2)}, []interface{}{true}},
+		}},
+		{"quoting", map[string]interface{}{
+			"hash":     "not # a comment",
+			"escapes":  "a \"quoted\" \\ word\n\tindented",
+			"literal":  `C:\path # too`,
+			"rate":     0.5,
+			"negative": int64(-3),
+			"enabled":  false,
+		}},
+	}
+
+	if len(tables) != len(expected) {
+		t.Fatalf("parsed %d tables, expected %d", len(tables), len(expected))
+	}
+
+	for i, table := range tables {
+		if table.name != expected[i].name {
+			t.Errorf("table %d is [%s], expected [%s]", i, table.name, expected[i].name)
+		}
+
+		if values := tomlTableValues(table); !reflect.DeepEqual(values, expected[i].values) {
+			t.Errorf("[%s] holds %#v, expected %#v", table.name, values, expected[i].values)
+		}
+	}
+
+	// Values remember the line they started on
+	if line := tables[2].values["upstreams"].line; line != 8 {
+		t.Errorf("upstreams is on line %d, expected 8", line)
+	}
+}
+
+func TestParseTOMLRejectsInvalidInput(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"duplicate key", "[a]\nkey = 1\nkey = 2", `line 3: key "key" defined twice`},
+		{"duplicate table", "[a]\n[b]\n[a]", "line 3: table [a] defined twice"},
+		{"same key in two tables", "[a]\nkey = 1\n[b]\nkey = 2", ""},
+		{"bare string", "[a]\nkey = value", `line 2: invalid value "value", strings must be quoted`},
+		{"unterminated string", "[a]\nkey = \"value", "line 2: unterminated string"},
+		{"unterminated literal string", "[a]\nkey = 'value", "line 2: unterminated string"},
+		{"unsupported escape", `key = "\x41"`, `line 1: unsupported escape \x`},
+		{"unterminated array", "[a]\nkey = [1,\n2,", "line 2: unterminated array"},
+		{"missing comma", "key = [1 2]", "line 1: expected , or ] in array"},
+		{"missing value", "key =", "line 1: missing value"},
+		{"text after value", `key = "a" "b"`, `line 1: unexpected "\"b\"" after value of "key"`},
+		{"missing equals sign", "[a]\nkey", "line 2: expected key = value"},
+		{"dotted key", "a.b = 1", `line 1: invalid key "a.b"`},
+		{"array of tables", "[[a]]", "line 1: invalid table header [[a]]"},
+		{"unclosed table header", "[a", "line 1: invalid table header [a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseTOML(test.content)
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != test.err {
+				t.Fatalf("error is %v, expected %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// Serves requests over UDP. Every request is resolved in its own goroutine,
+// so that a slow upstream only delays the requests waiting for it. At most
+// maxConcurrency requests are resolved at the same time; once that many are
+// in flight the server stops reading until one of them is answered.
+type udpServer struct {
+	conn    *net.UDPConn
+	handler *requestHandler
+	// Holds a token for every request being resolved.
+	slots chan struct{}
+
+	// Requests being resolved, which are still answered after Shutdown.
+	inFlight     sync.WaitGroup
+	shuttingDown atomic.Bool
+	// Closed once Serve returns.
+	stopped chan struct{}
+}
+
+func listenUDP(address string, handler *requestHandler, maxConcurrency int) (*udpServer, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	udpConn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &udpServer{
+		conn:    udpConn,
+		handler: handler,
+		slots:   make(chan struct{}, maxConcurrency),
+		stopped: make(chan struct{}),
+	}, nil
+}
+
+// Serves requests until the server is shut down or reading from the socket
+// fails.
+func (s *udpServer) Serve() {
+	defer close(s.stopped)
+
+	for {
+		s.slots <- struct{}{}
+
+		// Every request gets its own buffer, as it outlives this iteration
+		buf := make([]byte, maxUDPPayloadSize)
+		size, source, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if !s.shuttingDown.Load() {
+				fmt.Println("Error receiving data:", err)
+			}
+			break
+		}
+
+		s.inFlight.Add(1)
+		go func() {
+			defer s.inFlight.Done()
+			defer func() { <-s.slots }()
+
+			s.serveRequest(buf[:size], source)
+		}()
+	}
+}
+
+func (s *udpServer) serveRequest(data []byte, source *net.UDPAddr) {
+	info := &dns.RequestInfo{
+		ClientAddr:      source,
+		ServerAddr:      s.conn.LocalAddr(),
+		Transport:       dns.TransportUDP,
+		MaxResponseSize: dns.MIN_UDP_PAYLOAD_SIZE,
+		ReceivedAt:      time.Now(),
+	}
+
+	_, response := s.handler.handle(data, info)
+	s.respondWithMessage(source, response, info.MaxResponseSize)
+}
+
+// Sends the response to the source of the request. maxSize is the largest
+// UDP payload the source is able to receive.
+func (s *udpServer) respondWithMessage(source *net.UDPAddr, message *dns.Message, maxSize int) {
+	// Responses which don't fit are truncated, so that the client retries
+	// over TCP
+	serializedResponse, err := message.SerializeTruncated(maxSize)
+	if err != nil {
+		fmt.Println("Failed to serialize response:", err)
+		return
+	}
+
+	// The socket is closed once aborted requests are answered on shutdown
+	_, err = s.conn.WriteToUDP(serializedResponse, source)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		fmt.Println("Failed to send response:", err)
+	}
+}
+
+// Stops reading requests. The socket stays open, so that the requests being
+// resolved can still be answered.
+func (s *udpServer) Shutdown() {
+	s.shuttingDown.Store(true)
+
+	// Interrupts the read in progress, and fails all following ones
+	err := s.conn.SetReadDeadline(time.Now())
+	if err != nil {
+		fmt.Println("Failed to stop reading requests:", err)
+	}
+}
+
+// Waits until Serve has returned and every request read has been answered.
+func (s *udpServer) Wait() {
+	<-s.stopped
+	s.inFlight.Wait()
+}
+
+func (s *udpServer) Close() {
+	s.conn.Close()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// The file the default server is read from.
+const resolvConfPath = "/etc/resolv.conf"
+
+type args struct {
+	// The server to query, with or without a port.
+	server string
+	// The port the server is queried on, unless the server includes one.
+	port int
+	// The question to ask.
+	name   *dns.DomainName
+	qtype  dns.ResourceRecordType
+	qclass dns.ResourceRecordClass
+	// Whether to query over TCP instead of UDP.
+	tcp bool
+	// Flags to set in the query.
+	rd bool
+	cd bool
+	do bool
+	// The UDP payload size advertised via EDNS, 0 to send no OPT record.
+	bufSize uint
+	// How often a query which timed out is repeated.
+	retries uint
+	// How long to wait for a response, in seconds.
+	timeout uint
+	// Whether to print the response as JSON.
+	json bool
+}
+
+// Parses the command line:
+//
+//	dig [flags] [@server] name [type] [class]
+//
+// The type and class may also be given as flags.
+func parseArgs() (*args, error) {
+	a := &args{}
+	var typeName, className string
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [@server] name [type] [class]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.StringVar(&a.server, "server", "", "Server to query, the first nameserver of "+resolvConfPath+" by default")
+	flag.IntVar(&a.port, "port", 53, "Port to query the server on, unless the server includes one")
+	flag.StringVar(&typeName, "type", "A", "Record type to query for")
+	flag.StringVar(&className, "class", "IN", "Class to query for")
+	flag.BoolVar(&a.tcp, "tcp", false, "Query over TCP instead of UDP")
+	flag.BoolVar(&a.rd, "rd", true, "Set the RD (recursion desired) flag")
+	flag.BoolVar(&a.cd, "cd", false, "Set the CD (checking disabled) flag")
+	flag.BoolVar(&a.do, "do", false, "Set the DO (DNSSEC OK) flag, which requires EDNS")
+	flag.UintVar(&a.bufSize, "bufsize", 1232, "UDP payload size to advertise via EDNS, 0 disables EDNS")
+	flag.UintVar(&a.retries, "retries", 2, "How often to repeat a query which timed out")
+	flag.UintVar(&a.timeout, "timeout", 5, "Seconds to wait for a response")
+	flag.BoolVar(&a.json, "json", false, "Print the response as JSON")
+	flag.Parse()
+
+	positional := flag.Args()
+	if len(positional) > 0 && strings.HasPrefix(positional[0], "@") {
+		a.server = positional[0][1:]
+		positional = positional[1:]
+	}
+
+	if len(positional) == 0 {
+		return nil, fmt.Errorf("no name to query")
+	}
+
+	if len(positional) > 3 {
+		return nil, fmt.Errorf("unexpected arguments %q", positional[3:])
+	}
+
+	root := &dns.DomainName{Labels: make([]dns.Label, 0)}
+	name, err := dns.ParseDomainName(positional[0], root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name %q: %w", positional[0], err)
+	}
+	a.name = name
+
+	if len(positional) > 1 {
+		typeName = positional[1]
+	}
+	if len(positional) > 2 {
+		className = positional[2]
+	}
+
+	a.qtype, err = dns.ParseResourceRecordType(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	a.qclass, err = dns.ParseResourceRecordClass(className)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.server == "" {
+		a.server, err = defaultServer()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = a.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *args) validate() error {
+	if a.port < 1 || a.port > 0xFFFF {
+		return fmt.Errorf("invalid port %d", a.port)
+	}
+
+	if a.bufSize > 0xFFFF {
+		return fmt.Errorf("EDNS buffer size %d exceeds maximum of %d", a.bufSize, 0xFFFF)
+	}
+
+	if a.do && a.bufSize == 0 {
+		return fmt.Errorf("-do requires EDNS, which -bufsize 0 disables")
+	}
+
+	if a.timeout == 0 {
+		return fmt.Errorf("timeout must be at least 1 second")
+	}
+
+	return nil
+}
+
+// Returns the address of the server, including the port.
+func (a *args) serverAddress() string {
+	if _, _, err := net.SplitHostPort(a.server); err == nil {
+		return a.server
+	}
+
+	return net.JoinHostPort(strings.Trim(a.server, "[]"), strconv.Itoa(a.port))
+}
+
+// Returns the first nameserver of resolv.conf, like dig does.
+func defaultServer() (string, error) {
+	file, err := os.Open(resolvConfPath)
+	if err != nil {
+		return "", fmt.Errorf("no server given and %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return fields[1], nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("no server given and no nameserver in %s", resolvConfPath)
+}
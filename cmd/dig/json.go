@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+// The response as printed with -json. RDATA is given in presentation format.
+type jsonResponse struct {
+	Server      string         `json:"server"`
+	Transport   string         `json:"transport"`
+	QueryTimeMs int64          `json:"query_time_ms"`
+	ID          uint16         `json:"id"`
+	Opcode      string         `json:"opcode"`
+	Status      string         `json:"status"`
+	Flags       []string       `json:"flags"`
+	EDNS        *jsonEDNS      `json:"edns,omitempty"`
+	Question    []jsonQuestion `json:"question"`
+	Answer      []jsonRecord   `json:"answer"`
+	Authority   []jsonRecord   `json:"authority"`
+	Additional  []jsonRecord   `json:"additional"`
+}
+
+type jsonEDNS struct {
+	Version uint8  `json:"version"`
+	UDPSize uint16 `json:"udp_size"`
+	DO      bool   `json:"do"`
+}
+
+type jsonQuestion struct {
+	Name  string `json:"name"`
+	Class string `json:"class"`
+	Type  string `json:"type"`
+}
+
+type jsonRecord struct {
+	Name  string `json:"name"`
+	TTL   uint32 `json:"ttl"`
+	Class string `json:"class"`
+	Type  string `json:"type"`
+	Data  string `json:"data"`
+}
+
+func printJSON(a *args, response *dns.Message, transport dns.Transport, elapsed time.Duration) error {
+	output := jsonResponse{
+		Server:      a.serverAddress(),
+		Transport:   transport.String(),
+		QueryTimeMs: elapsed.Milliseconds(),
+		ID:          response.Header.ID,
+		Opcode:      response.Header.Flags.OPCODE.String(),
+		Status:      response.ResponseCode().String(),
+		Flags:       response.Header.Flags.Names(),
+		Question:    make([]jsonQuestion, 0, len(response.Questions)),
+		Answer:      jsonRecords(response.Answers),
+		Authority:   jsonRecords(response.Authority),
+		Additional:  jsonRecords(response.Additional),
+	}
+
+	if edns := response.EDNS(); edns != nil {
+		output.EDNS = &jsonEDNS{
+			Version: edns.Version,
+			UDPSize: edns.UDPSize,
+			DO:      edns.DO,
+		}
+	}
+
+	for _, question := range response.Questions {
+		output.Question = append(output.Question, jsonQuestion{
+			Name:  question.Name.String(),
+			Class: question.Class.String(),
+			Type:  question.Type.String(),
+		})
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(output)
+}
+
+// Converts the records of a section, leaving out the OPT record, which is
+// given as the edns field instead.
+func jsonRecords(records []dns.ResourceRecord) []jsonRecord {
+	converted := make([]jsonRecord, 0, len(records))
+	for _, record := range records {
+		if record.Type == dns.TYPE_OPT {
+			continue
+		}
+
+		converted = append(converted, jsonRecord{
+			Name:  record.Name.String(),
+			TTL:   record.TTL,
+			Class: record.Class.String(),
+			Type:  record.Type.String(),
+			Data:  record.RData.String(),
+		})
+	}
+
+	return converted
+}
@@ -0,0 +1,138 @@
+// A dig-like client, which queries a DNS server over UDP or TCP and prints
+// the response.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/codecrafters-io/dns-server-starter-go/app/dns"
+)
+
+func main() {
+	a, err := parseArgs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid arguments:", err)
+		os.Exit(2)
+	}
+
+	request, err := buildQuery(a)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to build query:", err)
+		os.Exit(1)
+	}
+
+	start := time.Now()
+	response, transport, err := query(a, request)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, ";; connection to %s failed: %v\n", a.serverAddress(), err)
+		os.Exit(9)
+	}
+	elapsed := time.Since(start)
+
+	if a.json {
+		err = printJSON(a, response, transport, elapsed)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to print response:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	printResponse(a, response, transport, elapsed)
+}
+
+func buildQuery(a *args) (*dns.Message, error) {
+	buf := make([]byte, 2)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &dns.Message{
+		Header: dns.Header{
+			ID: binary.BigEndian.Uint16(buf),
+			Flags: dns.Flags{
+				QR:     false,
+				OPCODE: dns.OpcodeQuery,
+				RD:     a.rd,
+			},
+			QDCOUNT: 1,
+		},
+		Questions: []dns.Question{{
+			Name:  *a.name,
+			Type:  a.qtype,
+			Class: a.qclass,
+		}},
+		Answers:    make([]dns.ResourceRecord, 0),
+		Authority:  make([]dns.ResourceRecord, 0),
+		Additional: make([]dns.ResourceRecord, 0),
+	}
+
+	if a.cd {
+		request.Header.Flags.Z |= dns.FLAG_Z_CD
+	}
+
+	if a.bufSize > 0 {
+		request.SetEDNS(&dns.EDNS{
+			UDPSize: uint16(a.bufSize),
+			Version: dns.EDNS_VERSION,
+			DO:      a.do,
+		})
+	}
+
+	return request, nil
+}
+
+// Sends the query over the requested transport, repeating it when it times
+// out. Truncated UDP responses are retried over TCP, like dig does. Returns
+// the transport the response was received over.
+func query(a *args, request *dns.Message) (*dns.Message, dns.Transport, error) {
+	server := a.serverAddress()
+	timeout := time.Duration(a.timeout) * time.Second
+
+	if !a.tcp {
+		response, err := withRetries(a, func() (*dns.Message, error) {
+			return dns.ExchangeUDP(context.Background(), request, server, timeout)
+		})
+		if err != nil || !response.Header.Flags.TC {
+			return response, dns.TransportUDP, err
+		}
+
+		fmt.Fprintln(os.Stderr, ";; Truncated, retrying in TCP mode.")
+	}
+
+	response, err := withRetries(a, func() (*dns.Message, error) {
+		return dns.ExchangeTCP(context.Background(), request, server, timeout)
+	})
+	return response, dns.TransportTCP, err
+}
+
+func withRetries(a *args, exchange func() (*dns.Message, error)) (*dns.Message, error) {
+	for attempt := uint(0); ; attempt++ {
+		response, err := exchange()
+		if err == nil || !errors.Is(err, context.DeadlineExceeded) || attempt == a.retries {
+			return response, err
+		}
+
+		fmt.Fprintf(os.Stderr, ";; communications error to %s: timed out\n", a.serverAddress())
+	}
+}
+
+func printResponse(a *args, response *dns.Message, transport dns.Transport, elapsed time.Duration) {
+	fmt.Printf("; <<>> dig <<>> @%s %s %s\n", a.server, a.name.String(), a.qtype)
+	fmt.Println(";; Got answer:")
+	fmt.Println(response.String())
+
+	host, port, _ := net.SplitHostPort(a.serverAddress())
+	fmt.Printf(";; Query time: %d msec\n", elapsed.Milliseconds())
+	fmt.Printf(";; SERVER: %s#%s(%s)\n", host, port, transport)
+	fmt.Printf(";; WHEN: %s\n", time.Now().Format(time.UnixDate))
+	fmt.Println()
+}